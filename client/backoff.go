@@ -0,0 +1,88 @@
+package client
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff decides how long to wait before a retry. attempt is the number of
+// the retry about to be made, starting at 1, and previous is the delay that
+// was used before the last retry.
+type Backoff interface {
+	Delay(attempt int, previous time.Duration) time.Duration
+}
+
+type constantBackoff struct {
+	delay time.Duration
+}
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return constantBackoff{delay: delay}
+}
+
+func (b constantBackoff) Delay(int, time.Duration) time.Duration {
+	return b.delay
+}
+
+type exponentialBackoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+// ExponentialBackoff doubles the delay on every retry, starting at min and
+// never waiting longer than max.
+func ExponentialBackoff(min, max time.Duration) Backoff {
+	return exponentialBackoff{min: min, max: max}
+}
+
+func (b exponentialBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	delay := b.min
+	for i := 1; i < attempt && delay < b.max; i++ {
+		delay *= 2
+	}
+	return min(delay, b.max)
+}
+
+type decorrelatedJitterBackoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+// DecorrelatedJitterBackoff picks a random delay between min and three times
+// the previous delay, capped at max, so that clients retrying together spread
+// out over time.
+func DecorrelatedJitterBackoff(min, max time.Duration) Backoff {
+	return decorrelatedJitterBackoff{min: min, max: max}
+}
+
+func (b decorrelatedJitterBackoff) Delay(_ int, previous time.Duration) time.Duration {
+	upper := max(previous*3, b.min)
+	delay := b.min
+	if upper > b.min {
+		delay += rand.N(upper - b.min)
+	}
+	return min(delay, b.max)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a 429 or
+// 503 response.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
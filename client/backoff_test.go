@@ -0,0 +1,275 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitBackoff(t *testing.T) {
+	spec.Run(t, "Backoff Test", testBackoff, spec.Report(report.Terminal{}))
+}
+
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	waits  []time.Duration
+	blocks bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.waits = append(f.waits, d)
+	ch := make(chan time.Time, 1)
+	if !f.blocks {
+		f.now = f.now.Add(d)
+		ch <- f.now
+	}
+	return ch
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeClock) Waits() []time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]time.Duration(nil), f.waits...)
+}
+
+// growingBackoff waits a second longer than the previous delay, recording the
+// previous delays it is given.
+type growingBackoff struct {
+	previous []time.Duration
+}
+
+func (g *growingBackoff) Delay(_ int, previous time.Duration) time.Duration {
+	g.previous = append(g.previous, previous)
+	return previous + time.Second
+}
+
+func testBackoff(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server      *httptest.Server
+		clock       *fakeClock
+		retryAfter  string
+		failures    int
+		failureCode int
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		clock = newFakeClock()
+		retryAfter = ""
+		failures = 3
+		failureCode = http.StatusInternalServerError
+		requests := 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests > failures {
+				_, _ = fmt.Fprintf(w, "200 on request %d", requests)
+				return
+			}
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(failureCode)
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("ConstantBackoff", func() {
+		it("always returns the same delay", func() {
+			backoff := client.ConstantBackoff(time.Second)
+
+			Expect(backoff.Delay(1, 0)).To(Equal(time.Second))
+			Expect(backoff.Delay(5, time.Second)).To(Equal(time.Second))
+		})
+	})
+
+	when("ExponentialBackoff", func() {
+		it("doubles the delay up to the max", func() {
+			backoff := client.ExponentialBackoff(100*time.Millisecond, time.Second)
+
+			Expect(backoff.Delay(1, 0)).To(Equal(100 * time.Millisecond))
+			Expect(backoff.Delay(2, 0)).To(Equal(200 * time.Millisecond))
+			Expect(backoff.Delay(4, 0)).To(Equal(800 * time.Millisecond))
+			Expect(backoff.Delay(5, 0)).To(Equal(time.Second))
+			Expect(backoff.Delay(100, 0)).To(Equal(time.Second))
+		})
+	})
+
+	when("DecorrelatedJitterBackoff", func() {
+		it("stays between min and three times the previous delay, capped at max", func() {
+			backoff := client.DecorrelatedJitterBackoff(100*time.Millisecond, time.Second)
+
+			previous := time.Duration(0)
+			for i := 1; i <= 50; i++ {
+				delay := backoff.Delay(i, previous)
+				Expect(delay).To(BeNumerically(">=", 100*time.Millisecond))
+				Expect(delay).To(BeNumerically("<=", max(previous*3, 100*time.Millisecond)))
+				Expect(delay).To(BeNumerically("<=", time.Second))
+				previous = delay
+			}
+		})
+	})
+
+	when("retrying", func() {
+		it("waits for the backoff delay between attempts", func() {
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(
+				client.ExponentialBackoff(100*time.Millisecond, time.Second),
+			))
+
+			body, err := callout.Get(server.URL, client.WithRetries(3))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 4"))
+			Expect(clock.Waits()).To(Equal([]time.Duration{
+				100 * time.Millisecond,
+				200 * time.Millisecond,
+				400 * time.Millisecond,
+			}))
+		})
+
+		it("passes the previous delay to the backoff", func() {
+			backoff := &growingBackoff{}
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(backoff))
+
+			_, err := callout.Get(server.URL, client.WithRetries(3))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backoff.previous).To(Equal([]time.Duration{0, time.Second, 2 * time.Second}))
+			Expect(clock.Waits()).To(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}))
+		})
+
+		it("prefers the backoff on the request", func() {
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(
+				client.ConstantBackoff(time.Minute),
+			))
+
+			_, err := callout.Get(server.URL, client.WithRetries(3), client.WithBackoff(client.ConstantBackoff(time.Second)))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{time.Second, time.Second, time.Second}))
+		})
+
+		it("does not wait without a backoff", func() {
+			callout := client.New(client.WithClock(clock))
+
+			_, err := callout.Get(server.URL, client.WithRetries(3))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(BeEmpty())
+		})
+
+		it("honours a Retry-After header in seconds on a 503", func() {
+			failures = 1
+			failureCode = http.StatusServiceUnavailable
+			retryAfter = "7"
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(client.ConstantBackoff(time.Second)))
+
+			_, err := callout.Get(server.URL, client.WithRetries(1))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{7 * time.Second}))
+		})
+
+		it("honours a Retry-After header with an HTTP date", func() {
+			failures = 1
+			failureCode = http.StatusServiceUnavailable
+			retryAfter = clock.Now().Add(30 * time.Second).Format(http.TimeFormat)
+			callout := client.New(client.WithClock(clock))
+
+			_, err := callout.Get(server.URL, client.WithRetries(1))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{30 * time.Second}))
+		})
+
+		it("ignores a Retry-After header shorter than the backoff delay", func() {
+			failures = 1
+			failureCode = http.StatusServiceUnavailable
+			retryAfter = "1"
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(client.ConstantBackoff(time.Minute)))
+
+			_, err := callout.Get(server.URL, client.WithRetries(1))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{time.Minute}))
+		})
+
+		it("gives up when a Retry-After header asks for longer than the limit", func() {
+			failures = 1
+			failureCode = http.StatusServiceUnavailable
+			retryAfter = "86400"
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(client.ExponentialBackoff(10*time.Millisecond, time.Second)))
+
+			response, err := callout.Send(http.MethodGet, server.URL, "", client.WithRetries(1))
+
+			var respErr client.ResponseError
+			Expect(errors.As(err, &respErr)).To(BeTrue())
+			Expect(respErr.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Attempts).To(Equal(1))
+			Expect(clock.Waits()).To(BeEmpty())
+
+			failures = 2
+			retryAfter = "120"
+			callout = client.New(client.WithClock(clock), client.WithMaxRetryAfter(5*time.Minute))
+			_, err = callout.Get(server.URL, client.WithRetries(1))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{2 * time.Minute}))
+		})
+
+		it("ignores a Retry-After header on other status codes", func() {
+			failures = 1
+			retryAfter = "7"
+			callout := client.New(client.WithClock(clock))
+
+			_, err := callout.Get(server.URL, client.WithRetries(1))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(BeEmpty())
+		})
+
+		it("stops waiting when the context is cancelled", func() {
+			clock.blocks = true
+			callout := client.New(client.WithClock(clock), client.WithDefaultBackoff(client.ConstantBackoff(time.Hour)))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err := callout.Get(server.URL, client.WithRetries(3), client.WithContext(ctx))
+
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(clock.Waits()).To(Equal([]time.Duration{time.Hour}))
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const defaultMaxBufferedBody = 1 << 20
+
+var errBodyNotReplayable = errors.New("request body cannot be replayed")
+
+// requestBody opens a fresh reader over the request body for every attempt.
+// Bodies that are not replayable can only be opened once and are never
+// retried.
+type requestBody struct {
+	open       func() (io.ReadCloser, error)
+	length     int64
+	replayable bool
+	// headers describe the body, such as its Content-Type. They override the
+	// default headers on the Callout but not the headers set on the request.
+	headers map[string]string
+}
+
+func (b *requestBody) canReplay() bool {
+	return b == nil || b.replayable
+}
+
+// bodySource builds the request body once all options have been applied, so
+// it can use the buffering limit whichever order the options were given in.
+type bodySource func(maxBuffered int64) (*requestBody, error)
+
+func bytesBody(b []byte) *requestBody {
+	if len(b) == 0 {
+		return nil
+	}
+	return &requestBody{
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		},
+		length:     int64(len(b)),
+		replayable: true,
+	}
+}
+
+func jsonBody(v interface{}) (*requestBody, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	body := bytesBody(b)
+	body.headers = map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
+	}
+	return body, nil
+}
+
+func funcBody(open func() (io.ReadCloser, error)) *requestBody {
+	return &requestBody{
+		open:       open,
+		length:     -1,
+		replayable: true,
+	}
+}
+
+// readerBody replays readers that support random access directly. Other
+// readers are buffered in memory when they fit in maxBuffered bytes and are
+// otherwise streamed once without being retried. A nil reader is no body.
+func readerBody(r io.Reader, maxBuffered int64) (*requestBody, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if body, ok, err := seekableBody(r); ok || err != nil {
+		return body, err
+	}
+
+	closer, _ := r.(io.Closer)
+	if maxBuffered <= 0 {
+		return onceBody(r, closer), nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r, maxBuffered+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(buf)) > maxBuffered {
+		return onceBody(io.MultiReader(bytes.NewReader(buf), r), closer), nil
+	}
+	if closer != nil {
+		_ = closer.Close()
+	}
+	return bytesBody(buf), nil
+}
+
+func seekableBody(r io.Reader) (*requestBody, bool, error) {
+	type readSeekerAt interface {
+		io.ReaderAt
+		io.Seeker
+	}
+	seeker, ok := r.(readSeekerAt)
+	if !ok {
+		return nil, false, nil
+	}
+
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false, nil
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to find request body size: %w", err)
+	}
+	if _, err = seeker.Seek(offset, io.SeekStart); err != nil {
+		return nil, true, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+
+	return &requestBody{
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(seeker, offset, end-offset)), nil
+		},
+		length:     end - offset,
+		replayable: true,
+	}, true, nil
+}
+
+func onceBody(r io.Reader, closer io.Closer) *requestBody {
+	opened := false
+	return &requestBody{
+		open: func() (io.ReadCloser, error) {
+			if opened {
+				return nil, errBodyNotReplayable
+			}
+			opened = true
+
+			if closer != nil {
+				return struct {
+					io.Reader
+					io.Closer
+				}{r, closer}, nil
+			}
+			return io.NopCloser(r), nil
+		},
+		length: -1,
+	}
+}
@@ -0,0 +1,208 @@
+package client_test
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnitBody(t *testing.T) {
+	spec.Run(t, "Body Test", testBody, spec.Report(report.Terminal{}))
+}
+
+// streamReader hides any methods of the underlying reader that would let the
+// body be rewound.
+type streamReader struct {
+	io.Reader
+}
+
+func testBody(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server       *httptest.Server
+		requestCount int
+		received     []string
+		headers      http.Header
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requestCount = 0
+		received = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCount++
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, string(body))
+			headers = r.Header
+
+			if requestCount < 3 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = fmt.Fprint(w, string(body))
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	retryAll := client.WithRetryPolicy(client.DefaultRetryPolicy{RetryNonIdempotent: true})
+
+	it("sends the string body on every retry", func() {
+		callout := client.New()
+
+		body, err := callout.Post(server.URL, "foobar", client.WithRetries(2), retryAll)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("foobar"))
+		Expect(received).To(Equal([]string{"foobar", "foobar", "foobar"}))
+	})
+
+	when("WithBodyBytes", func() {
+		it("sends the bytes on every retry and replaces the string body", func() {
+			callout := client.New()
+
+			body, err := callout.Post(server.URL, "ignored", client.WithBodyBytes([]byte("foobar")), client.WithRetries(2), retryAll)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+			Expect(received).To(Equal([]string{"foobar", "foobar", "foobar"}))
+		})
+	})
+
+	when("WithBodyFunc", func() {
+		it("opens a new body for every attempt", func() {
+			callout := client.New()
+
+			opened := 0
+			open := func() (io.ReadCloser, error) {
+				opened++
+				return io.NopCloser(strings.NewReader(fmt.Sprintf("body %d", opened))), nil
+			}
+			body, err := callout.Put(server.URL, "", client.WithBodyFunc(open), client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("body 3"))
+			Expect(received).To(Equal([]string{"body 1", "body 2", "body 3"}))
+		})
+	})
+
+	when("WithBody", func() {
+		it("re-reads a file from its starting offset on every retry", func() {
+			path := filepath.Join(t.TempDir(), "body")
+			Expect(os.WriteFile(path, []byte("skip:foobar"), 0600)).To(Succeed())
+			file, err := os.Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+			_, err = file.Seek(int64(len("skip:")), io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+
+			callout := client.New()
+			body, err := callout.Put(server.URL, "", client.WithBody(file), client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+			Expect(received).To(Equal([]string{"foobar", "foobar", "foobar"}))
+		})
+
+		it("sends no body for a nil reader", func() {
+			callout := client.New()
+
+			_, err := callout.Put(server.URL, "", client.WithBody(nil), client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received).To(Equal([]string{"", "", ""}))
+		})
+
+		it("buffers a stream that fits in the limit so it can be retried", func() {
+			callout := client.New()
+
+			reader := streamReader{bytes.NewBufferString("foobar")}
+			body, err := callout.Put(server.URL, "", client.WithBody(reader), client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+			Expect(received).To(Equal([]string{"foobar", "foobar", "foobar"}))
+		})
+
+		it("streams a body over the limit once without retrying it", func() {
+			callout := client.New(client.WithDefaultMaxBufferedBody(3))
+
+			reader := streamReader{bytes.NewBufferString("foobar")}
+			_, err := callout.Put(server.URL, "", client.WithBody(reader), client.WithRetries(2))
+
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+			Expect(received).To(Equal([]string{"foobar"}))
+		})
+
+		it("prefers the buffering limit on the request", func() {
+			callout := client.New(client.WithDefaultMaxBufferedBody(3))
+
+			reader := streamReader{bytes.NewBufferString("foobar")}
+			body, err := callout.Put(server.URL, "", client.WithBody(reader), client.WithRetries(2), client.WithMaxBufferedBody(6))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+			Expect(received).To(HaveLen(3))
+		})
+	})
+	when("JSONBody", func() {
+		it("encodes the value as JSON on every retry", func() {
+			callout := client.New()
+
+			value := struct {
+				Key string `json:"key"`
+			}{Key: "value"}
+			var result map[string]string
+			_, err := callout.Post(server.URL, "", client.JSONBody(value), client.UnmarshalJSONBody(&result), client.WithRetries(2), retryAll)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received).To(Equal([]string{`{"key":"value"}`, `{"key":"value"}`, `{"key":"value"}`}))
+			Expect(result).To(Equal(map[string]string{"key": "value"}))
+		})
+
+		it("sets the Content-Type and Accept headers over the default headers", func() {
+			callout := client.New(client.WithDefaultHeaders(map[string]string{
+				"Content-Type": "text/plain",
+				"Accept":       "text/plain",
+			}))
+
+			_, err := callout.Put(server.URL, "", client.JSONBody([]int{1, 2}), client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers.Get("Content-Type")).To(Equal("application/json"))
+			Expect(headers.Get("Accept")).To(Equal("application/json"))
+		})
+
+		it("does not override headers set on the request", func() {
+			callout := client.New()
+
+			_, err := callout.Put(server.URL, "", client.JSONBody([]int{1, 2}), client.WithRetries(2),
+				client.WithHeader("Content-Type", "application/vnd.api+json"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers.Get("Content-Type")).To(Equal("application/vnd.api+json"))
+			Expect(headers.Get("Accept")).To(Equal("application/json"))
+		})
+
+		it("returns an error without making a request when the value cannot be encoded", func() {
+			callout := client.New()
+
+			_, err := callout.Post(server.URL, "", client.JSONBody(make(chan int)))
+
+			Expect(err).To(MatchError(ContainSubstring("failed to marshal request body")))
+			Expect(requestCount).To(Equal(0))
+		})
+	})
+}
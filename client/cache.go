@@ -0,0 +1,533 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCachedBody is the largest response body that is cached.
+	maxCachedBody = 16 << 20
+	// maxHeuristicFreshness caps how long a response without an explicit
+	// expiry is fresh for, based on its Last-Modified header.
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+// heuristicallyCacheable are the statuses that can be cached without an
+// explicit expiry, as listed in RFC 9110. Partial content is left out.
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// httpCache is a private HTTP cache, as described in RFC 9111, for the GET and
+// HEAD requests made by a Callout.
+type httpCache struct {
+	store        Cache
+	clock        Clock
+	revalidating sync.Map
+}
+
+// cacheEntry is a stored response, along with the request header values it
+// varies on and the times used to calculate its age.
+type cacheEntry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	Vary         map[string]string
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// cacheMiddleware answers requests from the cache when it can, revalidates
+// stale responses and stores the responses that can be cached.
+func (c *Callout) cacheMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.cache == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return c.cache.roundTrip(req, next)
+	})
+}
+
+func (h *httpCache) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := next.RoundTrip(req)
+		if err == nil && req.Method != http.MethodOptions && resp.StatusCode < 400 {
+			h.invalidate(req.URL, resp)
+		}
+		return resp, err
+	}
+
+	reqControl := parseCacheControl(req.Header)
+	if !cacheableRequest(req, reqControl) {
+		return next.RoundTrip(req)
+	}
+
+	key := cacheKey(req.Method, req.URL)
+	entry := h.load(key, req)
+	now := h.clock.Now()
+	if entry != nil {
+		control := parseCacheControl(entry.Header)
+		age := entry.age(now)
+		staleness := age - entry.lifetime()
+		switch {
+		case entry.usable(control, reqControl, staleness):
+			return entry.response(req, age), nil
+		case entry.servableStale(control, reqControl, staleness, "stale-while-revalidate"):
+			resp := entry.response(req, age)
+			h.revalidate(req, key, entry, next)
+			return resp, nil
+		}
+	}
+	if reqControl.has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+
+	resp, err := h.fetch(req, key, entry, next)
+	if entry != nil && (err != nil || serverError(resp.StatusCode)) {
+		now = h.clock.Now()
+		staleness := entry.age(now) - entry.lifetime()
+		if entry.servableStale(parseCacheControl(entry.Header), reqControl, staleness, "stale-if-error") {
+			if err == nil {
+				discardBody(resp)
+			}
+			return entry.response(req, entry.age(now)), nil
+		}
+	}
+	return resp, err
+}
+
+// fetch sends req, conditionally when there is a stored entry to revalidate,
+// and stores the response when it can be cached.
+func (h *httpCache) fetch(req *http.Request, key string, entry *cacheEntry, next http.RoundTripper) (*http.Response, error) {
+	conditional := req
+	if entry != nil {
+		conditional = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			conditional.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			conditional.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := h.clock.Now()
+	resp, err := next.RoundTrip(conditional)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := h.clock.Now()
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		discardBody(resp)
+		entry.update(resp.Header, requestTime, responseTime)
+		h.save(key, entry)
+		return entry.response(req, entry.age(responseTime)), nil
+	}
+
+	if storable(req, resp) {
+		entry := &cacheEntry{
+			StatusCode:   resp.StatusCode,
+			Header:       resp.Header.Clone(),
+			Vary:         varyValues(req, resp.Header),
+			RequestTime:  requestTime,
+			ResponseTime: responseTime,
+		}
+		resp.Body = &cachingBody{ReadCloser: resp.Body, onEOF: func(body []byte) {
+			entry.Body = body
+			h.save(key, entry)
+		}}
+	} else if resp.StatusCode < 400 {
+		h.store.Delete(key)
+	}
+	return resp, nil
+}
+
+// revalidate refreshes a stale entry in the background, unless it is already
+// being refreshed.
+func (h *httpCache) revalidate(req *http.Request, key string, entry *cacheEntry, next http.RoundTripper) {
+	if _, running := h.revalidating.LoadOrStore(key, true); running {
+		return
+	}
+
+	background := &call{opts: callFrom(req.Context()).opts}
+	req = req.WithContext(withCall(context.WithoutCancel(req.Context()), background))
+	go func() {
+		defer h.revalidating.Delete(key)
+		if resp, err := h.fetch(req, key, entry, next); err == nil {
+			discardBody(resp)
+		}
+	}()
+}
+
+// load returns the entry stored for req, or nil when there is none or it was
+// stored for a request with different values for the headers it varies on.
+func (h *httpCache) load(key string, req *http.Request) *cacheEntry {
+	data, ok := h.store.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		h.store.Delete(key)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if headerValue(req.Header, name) != value {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (h *httpCache) save(key string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	h.store.Set(key, data)
+}
+
+// invalidate removes the entries for the URL of a request that may have
+// changed it, and for the Location and Content-Location of the response when
+// they are on the same host.
+func (h *httpCache) invalidate(target *url.URL, resp *http.Response) {
+	targets := []*url.URL{target}
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := resp.Header.Get(name); value != "" {
+			if location, err := target.Parse(value); err == nil && location.Host == target.Host {
+				targets = append(targets, location)
+			}
+		}
+	}
+	for _, u := range targets {
+		h.store.Delete(cacheKey(http.MethodGet, u))
+		h.store.Delete(cacheKey(http.MethodHead, u))
+	}
+}
+
+// usable reports whether the entry can be used without revalidating it, given
+// how long it has been stale for, which is negative while it is fresh.
+func (e *cacheEntry) usable(control, reqControl cacheControl, staleness time.Duration) bool {
+	if control.has("no-cache") || reqControl.has("no-cache") {
+		return false
+	}
+	if maxAge, ok := reqControl.seconds("max-age"); ok && staleness+e.lifetime() > maxAge {
+		return false
+	}
+	if minFresh, ok := reqControl.seconds("min-fresh"); ok && -staleness < minFresh {
+		return false
+	}
+	if staleness < 0 {
+		return true
+	}
+	if control.has("must-revalidate") || !reqControl.has("max-stale") {
+		return false
+	}
+	maxStale, ok := reqControl.seconds("max-stale")
+	return !ok || staleness <= maxStale
+}
+
+// servableStale reports whether a stale entry can be used under the
+// stale-while-revalidate or stale-if-error directive, as given by the
+// response or the request.
+func (e *cacheEntry) servableStale(control, reqControl cacheControl, staleness time.Duration, directive string) bool {
+	if staleness < 0 || control.has("no-cache") || control.has("must-revalidate") {
+		return false
+	}
+	limit, ok := reqControl.seconds(directive)
+	if !ok {
+		limit, ok = control.seconds(directive)
+	}
+	return ok && staleness <= limit
+}
+
+// lifetime returns how long the entry is fresh for, from its max-age
+// directive, its Expires header or, for statuses that allow it, a tenth of the
+// time since it was last modified.
+func (e *cacheEntry) lifetime() time.Duration {
+	control := parseCacheControl(e.Header)
+	if maxAge, ok := control.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date := e.date()
+	if expires := e.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return expiresAt.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable[e.StatusCode] {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > maxHeuristicFreshness {
+			lifetime = maxHeuristicFreshness
+		}
+		return lifetime
+	}
+	return 0
+}
+
+// age returns the current age of the entry, as calculated in RFC 9111.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparentAge := e.ResponseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// update replaces the stored headers with those of a 304 Not Modified
+// response, other than the headers describing the body.
+func (e *cacheEntry) update(header http.Header, requestTime, responseTime time.Time) {
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding", "Connection":
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = responseTime
+}
+
+// response builds a response for req from the entry, marking the call as
+// answered from the cache.
+func (e *cacheEntry) response(req *http.Request, age time.Duration) *http.Response {
+	callFrom(req.Context()).cached = true
+
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// cachingBody keeps a copy of the body as it is read, passing it to onEOF once
+// all of it has been read, unless it was too large to cache.
+type cachingBody struct {
+	io.ReadCloser
+	buffer   bytes.Buffer
+	tooLarge bool
+	done     bool
+	onEOF    func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.tooLarge {
+		if b.buffer.Len()+n > maxCachedBody {
+			b.tooLarge = true
+			b.buffer = bytes.Buffer{}
+		} else {
+			b.buffer.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.tooLarge && !b.done {
+		b.done = true
+		b.onEOF(b.buffer.Bytes())
+	}
+	return n, err
+}
+
+// cacheControl holds the directives of Cache-Control headers, with the value
+// of each directive that has one.
+type cacheControl map[string]string
+
+// parseCacheControl parses the Cache-Control headers, falling back to a
+// Pragma: no-cache header when there are none.
+func parseCacheControl(header http.Header) cacheControl {
+	control := cacheControl{}
+	values := header.Values("Cache-Control")
+	if len(values) == 0 && strings.Contains(strings.ToLower(header.Get("Pragma")), "no-cache") {
+		control["no-cache"] = ""
+	}
+	for _, value := range values {
+		for _, directive := range splitDirectives(value) {
+			name, argument, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				control[name] = strings.Trim(strings.TrimSpace(argument), `"`)
+			}
+		}
+	}
+	return control
+}
+
+// splitDirectives splits a Cache-Control header on the commas that are not
+// inside a quoted string.
+func splitDirectives(value string) []string {
+	var directives []string
+	quoted := false
+	start := 0
+	for i, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			directives = append(directives, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(directives, value[start:])
+}
+
+func (c cacheControl) has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// seconds returns the value of a directive given in seconds. A directive with
+// an invalid value is treated as zero seconds.
+func (c cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := c[name]
+	if !ok || value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// cacheableRequest reports whether the cache is used for req. Requests that
+// are already conditional or ask for a range go straight to the server.
+func cacheableRequest(req *http.Request, control cacheControl) bool {
+	if control.has("no-store") {
+		return false
+	}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// storable reports whether resp can be stored, which needs it to be fresh for
+// a while or to have a validator to revalidate it with. As a Callout is often
+// shared by the users of a server, responses to requests with credentials are
+// only stored when they are marked as shareable with public or s-maxage.
+func storable(req *http.Request, resp *http.Response) bool {
+	control := parseCacheControl(resp.Header)
+	if control.has("no-store") || headerValue(resp.Header, "Vary") == "*" {
+		return false
+	}
+	if hasCredentials(req) && !control.has("public") && !control.has("s-maxage") {
+		return false
+	}
+	if resp.ContentLength > maxCachedBody {
+		return false
+	}
+
+	_, hasMaxAge := control.seconds("max-age")
+	explicit := hasMaxAge || resp.Header.Get("Expires") != ""
+	if !explicit && !heuristicallyCacheable[resp.StatusCode] {
+		return false
+	}
+	return explicit || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// hasCredentials reports whether req carries credentials that the response
+// may depend on.
+func hasCredentials(req *http.Request) bool {
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		if req.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// varyValues returns the values of the request headers named by the Vary
+// header of the response.
+func varyValues(req *http.Request, header http.Header) map[string]string {
+	values := map[string]string{}
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				values[name] = headerValue(req.Header, name)
+			}
+		}
+	}
+	return values
+}
+
+func headerValue(header http.Header, name string) string {
+	return strings.Join(header.Values(name), ", ")
+}
+
+func cacheKey(method string, u *url.URL) string {
+	return method + " " + u.String()
+}
+
+func serverError(statusCode int) bool {
+	switch statusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// gatewayTimeout is the response to an only-if-cached request that cannot be
+// answered from the cache.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
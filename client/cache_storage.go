@@ -0,0 +1,150 @@
+package client
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache stores the responses cached by a Callout, as added with WithCache.
+// Entries are opaque bytes stored under keys made from the request method and
+// URL. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryCache is a Cache that keeps entries in memory, evicting the least
+// recently used entries once they take up more than its size limit.
+type MemoryCache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache returns a MemoryCache holding up to maxBytes of entries. A
+// maxBytes of zero means no limit.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).value, true
+}
+
+// Set stores value under key, unless it is larger than the size limit.
+func (m *MemoryCache) Set(key string, value []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.remove(key)
+	if m.maxBytes > 0 && int64(len(value)) > m.maxBytes {
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, value: value})
+	m.size += int64(len(value))
+	for m.maxBytes > 0 && m.size > m.maxBytes {
+		m.remove(m.order.Back().Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (m *MemoryCache) Delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.remove(key)
+}
+
+// Len returns the number of entries in the cache.
+func (m *MemoryCache) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.entries)
+}
+
+func (m *MemoryCache) remove(key string) {
+	element, ok := m.entries[key]
+	if !ok {
+		return
+	}
+	m.order.Remove(element)
+	delete(m.entries, key)
+	m.size -= int64(len(element.Value.(*memoryCacheEntry).value))
+}
+
+// DiskCache is a Cache that keeps each entry in a file in a directory, so
+// entries are kept across restarts. Entries that cannot be read or written
+// are treated as missing.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache storing entries in dir, which is created
+// when it does not exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	value, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set writes value to a temporary file that then replaces the entry, so
+// readers never see a partly written entry.
+func (d *DiskCache) Set(key string, value []byte) {
+	file, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), d.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+}
+
+func (d *DiskCache) Delete(key string) {
+	_ = os.Remove(d.path(key))
+}
+
+func (d *DiskCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(hash[:]))
+}
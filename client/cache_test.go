@@ -0,0 +1,334 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitCache(t *testing.T) {
+	spec.Run(t, "Cache Test", testCache, spec.Report(report.Terminal{}))
+}
+
+func testCache(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server   *httptest.Server
+		clock    *fakeClock
+		mutex    sync.Mutex
+		requests []*http.Request
+		handler  http.HandlerFunc
+	)
+
+	requestCount := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(requests)
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		clock = newFakeClock()
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r)
+			count := len(requests)
+			mutex.Unlock()
+
+			w.Header().Set("X-Count", fmt.Sprint(count))
+			handler(w, r)
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	newCallout := func(options ...client.CalloutOption) *client.Callout {
+		return client.New(append([]client.CalloutOption{client.WithClock(clock), client.WithCache(client.NewMemoryCache(0))}, options...)...)
+	}
+
+	it("returns fresh responses without making a request", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = fmt.Fprint(w, "reference data")
+		}
+		callout := newCallout()
+
+		first, err := callout.Send(http.MethodGet, server.URL, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Cached).To(BeFalse())
+
+		clock.Advance(30 * time.Second)
+		second, err := callout.Send(http.MethodGet, server.URL, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Cached).To(BeTrue())
+		Expect(second.Attempts).To(Equal(0))
+		Expect(string(second.Body)).To(Equal("reference data"))
+		Expect(second.Header.Get("Age")).To(Equal("30"))
+		Expect(requestCount()).To(Equal(1))
+	})
+
+	it("uses the Expires header when there is no max-age", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", clock.Now().Format(http.TimeFormat))
+			w.Header().Set("Expires", clock.Now().Add(time.Minute).Format(http.TimeFormat))
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		clock.Advance(59 * time.Second)
+		_, _ = callout.Get(server.URL)
+		Expect(requestCount()).To(Equal(1))
+
+		clock.Advance(time.Second)
+		_, _ = callout.Get(server.URL)
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("revalidates stale responses with their ETag", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=10")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = fmt.Fprint(w, "version 1")
+		}
+		callout := newCallout()
+
+		_, err := callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock.Advance(11 * time.Second)
+		response, err := callout.Send(http.MethodGet, server.URL, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Cached).To(BeTrue())
+		Expect(response.Attempts).To(Equal(1))
+		Expect(string(response.Body)).To(Equal("version 1"))
+		Expect(response.Header.Get("X-Count")).To(Equal("2"))
+
+		clock.Advance(5 * time.Second)
+		_, _ = callout.Get(server.URL)
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("revalidates with Last-Modified and replaces changed responses", func() {
+		lastModified := clock.Now().Add(-time.Hour).Format(http.TimeFormat)
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Last-Modified", lastModified)
+			_, _ = fmt.Fprintf(w, "body %s", w.Header().Get("X-Count"))
+		}
+		callout := newCallout()
+
+		body, err := callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("body 1"))
+
+		body, err = callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("body 2"))
+		Expect(requests[1].Header.Get("If-Modified-Since")).To(Equal(lastModified))
+	})
+
+	it("does not store responses with no-store", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		_, _ = callout.Get(server.URL)
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("does not share responses to requests with credentials", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = fmt.Fprint(w, r.Header.Get("Authorization"))
+		}
+		callout := newCallout()
+
+		body, _ := callout.Get(server.URL, client.WithHeader("Authorization", "Bearer alice"))
+		Expect(string(body)).To(Equal("Bearer alice"))
+		body, _ = callout.Get(server.URL, client.WithHeader("Authorization", "Bearer bob"))
+		Expect(string(body)).To(Equal("Bearer bob"))
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("shares public responses to requests with credentials", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			_, _ = fmt.Fprint(w, "shared")
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL, client.WithHeader("Authorization", "Bearer alice"))
+		body, _ := callout.Get(server.URL, client.WithHeader("Authorization", "Bearer bob"))
+		Expect(string(body)).To(Equal("shared"))
+		Expect(requestCount()).To(Equal(1))
+	})
+
+	it("follows the Cache-Control directives of the request", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		_, _ = callout.Get(server.URL, client.WithHeader("Cache-Control", "no-cache"))
+		Expect(requestCount()).To(Equal(2))
+
+		clock.Advance(30 * time.Second)
+		_, _ = callout.Get(server.URL, client.WithHeader("Cache-Control", "max-age=10"))
+		Expect(requestCount()).To(Equal(3))
+
+		clock.Advance(90 * time.Second)
+		_, _ = callout.Get(server.URL, client.WithHeader("Cache-Control", "max-stale=60"))
+		Expect(requestCount()).To(Equal(3))
+	})
+
+	it("keeps responses apart by the request headers they vary on", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept")
+			_, _ = fmt.Fprint(w, r.Header.Get("Accept"))
+		}
+		callout := newCallout()
+
+		body, _ := callout.Get(server.URL, client.WithHeader("Accept", "text/csv"))
+		Expect(string(body)).To(Equal("text/csv"))
+		body, _ = callout.Get(server.URL, client.WithHeader("Accept", "application/json"))
+		Expect(string(body)).To(Equal("application/json"))
+		body, _ = callout.Get(server.URL, client.WithHeader("Accept", "application/json"))
+		Expect(string(body)).To(Equal("application/json"))
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("returns stale responses while revalidating them in the background", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+			_, _ = fmt.Fprintf(w, "body %s", w.Header().Get("X-Count"))
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		clock.Advance(20 * time.Second)
+		body, err := callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("body 1"))
+
+		Eventually(func() string {
+			body, _ := callout.Get(server.URL)
+			return string(body)
+		}).Should(Equal("body 2"))
+		Expect(requestCount()).To(Equal(2))
+	})
+
+	it("returns stale responses when revalidating them fails", func() {
+		failing := false
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+			_, _ = fmt.Fprint(w, "cached")
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		failing = true
+		clock.Advance(30 * time.Second)
+		body, err := callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("cached"))
+
+		clock.Advance(time.Minute)
+		_, err = callout.Get(server.URL)
+		var respErr client.ResponseError
+		Expect(errors.As(err, &respErr)).To(BeTrue())
+		Expect(respErr.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	it("invalidates responses after an unsafe request", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		callout := newCallout()
+
+		_, _ = callout.Get(server.URL)
+		_, _ = callout.Post(server.URL, "update")
+		_, _ = callout.Get(server.URL)
+		Expect(requestCount()).To(Equal(3))
+	})
+
+	it("fails only-if-cached requests that are not cached", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {}
+		callout := newCallout()
+
+		_, err := callout.Get(server.URL, client.WithHeader("Cache-Control", "only-if-cached"))
+		var respErr client.ResponseError
+		Expect(errors.As(err, &respErr)).To(BeTrue())
+		Expect(respErr.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		Expect(requestCount()).To(Equal(0))
+	})
+
+	when("NewMemoryCache", func() {
+		it("evicts the least recently used entries", func() {
+			cache := client.NewMemoryCache(10)
+			cache.Set("a", []byte("aaaa"))
+			cache.Set("b", []byte("bbbb"))
+			_, _ = cache.Get("a")
+			cache.Set("c", []byte("cccc"))
+
+			_, ok := cache.Get("b")
+			Expect(ok).To(BeFalse())
+			value, ok := cache.Get("a")
+			Expect(ok).To(BeTrue())
+			Expect(string(value)).To(Equal("aaaa"))
+			Expect(cache.Len()).To(Equal(2))
+
+			cache.Set("d", []byte("too large to cache"))
+			Expect(cache.Len()).To(Equal(2))
+		})
+	})
+
+	when("NewDiskCache", func() {
+		it("keeps responses across callouts", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				_, _ = fmt.Fprint(w, "from disk")
+			}
+			dir := t.TempDir()
+
+			cache, err := client.NewDiskCache(dir)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.New(client.WithClock(clock), client.WithCache(cache)).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			cache, err = client.NewDiskCache(dir)
+			Expect(err).NotTo(HaveOccurred())
+			body, err := client.New(client.WithClock(clock), client.WithCache(cache)).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("from disk"))
+			Expect(requestCount()).To(Equal(1))
+
+			cache.Delete("GET " + server.URL)
+			_, _ = client.New(client.WithClock(clock), client.WithCache(cache)).Get(server.URL)
+			Expect(requestCount()).To(Equal(2))
+		})
+	})
+}
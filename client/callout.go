@@ -2,16 +2,16 @@ package client
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	_ "github.com/golang/mock/mockgen/model"
 	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
-	"net"
+	"log/slog"
 	"net/http"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,22 +19,52 @@ const (
 	defaultTimeout             = time.Minute
 	defaultDialTimeout         = 5 * time.Second
 	defaultTLSHandshakeTimeout = 5 * time.Second
+	defaultMaxRetryAfter       = time.Minute
 )
 
 type Caller interface {
 	Get(url string, options ...RequestOption) ([]byte, error)
 	Head(url string, options ...RequestOption) ([]byte, error)
 	Post(url, body string, options ...RequestOption) ([]byte, error)
+	Put(url, body string, options ...RequestOption) ([]byte, error)
+	Patch(url, body string, options ...RequestOption) ([]byte, error)
+	Delete(url string, options ...RequestOption) ([]byte, error)
+	Options(url string, options ...RequestOption) ([]byte, error)
+	Do(method, url, body string, options ...RequestOption) ([]byte, error)
+	Send(method, url, body string, options ...RequestOption) (*Response, error)
 }
 
 type Callout struct {
-	client         *http.Client
-	defaultContext context.Context
-	defaultHeaders map[string]string
-	defaultTimeout time.Duration
-	defaultRetries int
-	defaultTracer  trace.Tracer
-	skipTLSVerify  bool
+	client              *http.Client
+	transport           http.RoundTripper
+	baseTransport       http.RoundTripper
+	roundTripper        http.RoundTripper
+	middlewares         []Middleware
+	defaultHeaders      map[string]string
+	defaultTimeout      time.Duration
+	defaultRetries      int
+	defaultBackoff      Backoff
+	defaultPolicy       RetryPolicy
+	defaultMaxBuffered  int64
+	defaultTracer       trace.Tracer
+	defaultTraceContext context.Context
+	defaultHooks        []Hooks
+	maxRetryAfter       time.Duration
+	skipTLSVerify       bool
+	tls                 tlsSettings
+	pins                *PinSettings
+	proxy               proxySettings
+	connections         transportSettings
+	compression         compressionSettings
+	err                 error
+	closed              atomic.Bool
+	clock               Clock
+	breakerSettings     *CircuitBreakerSettings
+	breaker             *circuitBreaker
+	limiter             *rateLimiter
+	logger              *callLogger
+	metrics             Metrics
+	cache               *httpCache
 }
 
 // Ensure Callout implements Caller interface
@@ -42,127 +72,255 @@ var _ Caller = &Callout{}
 
 func New(options ...CalloutOption) *Callout {
 	callout := &Callout{
-		defaultTimeout: defaultTimeout,
+		defaultTimeout:     defaultTimeout,
+		defaultPolicy:      DefaultRetryPolicy{},
+		defaultMaxBuffered: defaultMaxBufferedBody,
+		maxRetryAfter:      defaultMaxRetryAfter,
+		clock:              systemClock{},
+		connections: transportSettings{
+			dialTimeout:         defaultDialTimeout,
+			tlsHandshakeTimeout: defaultTLSHandshakeTimeout,
+		},
 	}
 
 	for _, option := range options {
 		option(callout)
 	}
 
+	if callout.cache != nil {
+		callout.cache.clock = callout.clock
+	}
+
+	if callout.breakerSettings != nil {
+		settings := *callout.breakerSettings
+		if callout.metrics != nil {
+			settings.OnStateChange = callout.recordStateChange(settings.OnStateChange)
+		}
+		callout.breaker = newCircuitBreaker(settings, callout.clock)
+	}
+
+	transport := callout.baseTransport
+	if transport == nil {
+		transport, callout.err = callout.newTransport()
+	}
+	callout.roundTripper = transport
+
 	callout.client = &http.Client{
-		Timeout: callout.defaultTimeout,
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout: defaultDialTimeout,
-			}).DialContext,
-			TLSHandshakeTimeout: defaultTLSHandshakeTimeout,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: callout.skipTLSVerify,
-			},
-		},
+		Timeout:   callout.defaultTimeout,
+		Transport: chain(transport, callout.middlewares...),
 	}
+	callout.transport = chain(callout.clientRoundTripper(),
+		callout.headerMiddleware,
+		callout.callSpanMiddleware,
+		callout.cacheMiddleware,
+		callout.retryMiddleware,
+		callout.attemptSpanMiddleware,
+		callout.logMiddleware,
+		callout.circuitBreakerMiddleware,
+		callout.rateLimitMiddleware,
+		callout.metricsMiddleware,
+		callout.decompressMiddleware,
+	)
 
 	return callout
 }
 
+// newTransport builds the http.Transport used unless another transport is
+// given with WithTransport.
+func (c *Callout) newTransport() (http.RoundTripper, error) {
+	tlsConfig, err := c.tls.config(c.skipTLSVerify)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	if c.pins != nil {
+		logger := slog.Default()
+		if c.logger != nil {
+			logger = c.logger.logger
+		}
+		tlsConfig.VerifyConnection = newPinVerifier(*c.pins, logger).verifyConnection
+	}
+
+	dialer := c.connections.dialer()
+	transport := c.connections.transport(dialer)
+	transport.TLSClientConfig = tlsConfig
+
+	router, err := c.proxy.router(dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxy: %w", err)
+	}
+	if router != nil {
+		transport.Proxy = router.proxy
+		transport.DialContext = router.dialContext(dialer.DialContext)
+	}
+	return transport, nil
+}
+
 func (c *Callout) Get(url string, options ...RequestOption) ([]byte, error) {
-	return c.buildRequestWithOptions(http.MethodGet, url, "", options...)
+	return responseBody(c.buildRequestWithOptions(http.MethodGet, url, "", options...))
 }
 
 func (c *Callout) Head(url string, options ...RequestOption) ([]byte, error) {
-	return c.buildRequestWithOptions(http.MethodHead, url, "", options...)
+	return responseBody(c.buildRequestWithOptions(http.MethodHead, url, "", options...))
 }
 
 func (c *Callout) Post(url, body string, options ...RequestOption) ([]byte, error) {
-	return c.buildRequestWithOptions(http.MethodPost, url, body, options...)
+	return responseBody(c.buildRequestWithOptions(http.MethodPost, url, body, options...))
+}
+
+func (c *Callout) Put(url, body string, options ...RequestOption) ([]byte, error) {
+	return responseBody(c.buildRequestWithOptions(http.MethodPut, url, body, options...))
+}
+
+func (c *Callout) Patch(url, body string, options ...RequestOption) ([]byte, error) {
+	return responseBody(c.buildRequestWithOptions(http.MethodPatch, url, body, options...))
+}
+
+func (c *Callout) Delete(url string, options ...RequestOption) ([]byte, error) {
+	return responseBody(c.buildRequestWithOptions(http.MethodDelete, url, "", options...))
+}
+
+func (c *Callout) Options(url string, options ...RequestOption) ([]byte, error) {
+	return responseBody(c.buildRequestWithOptions(http.MethodOptions, url, "", options...))
+}
+
+// Do makes a request with an arbitrary method, going through the same
+// option and retry handling as the method specific helpers.
+func (c *Callout) Do(method, url, body string, options ...RequestOption) ([]byte, error) {
+	return responseBody(c.buildRequestWithOptions(method, url, body, options...))
+}
+
+// Send makes a request like Do, returning the full response. The response is
+// also returned alongside a ResponseError when the request fails with a non
+// 2XX status.
+func (c *Callout) Send(method, url, body string, options ...RequestOption) (*Response, error) {
+	return c.buildRequestWithOptions(method, url, body, options...)
+}
+
+func (c *Callout) buildRequestWithOptions(method string, url string, reqBody string, options ...RequestOption) (*Response, error) {
+	req, call, err := c.newRequest(method, url, options)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.send(req, call, url, reqBody)
+	if err != nil {
+		onError(call.opts.hooks, req, err)
+	}
+	return response, err
 }
 
-func (c *Callout) buildRequestWithOptions(method string, url string, reqBody string, options ...RequestOption) ([]byte, error) {
+// newRequest applies the options to a new request, which carries its call in
+// its context.
+func (c *Callout) newRequest(method string, url string, options []RequestOption) (*http.Request, *call, error) {
 	requestOpts := &requestOptions{
-		headers: c.defaultHeaders,
-		retries: c.defaultRetries,
-		tracer:  c.defaultTracer,
-		context: c.defaultContext,
+		maxBuffered:  c.defaultMaxBuffered,
+		retries:      c.defaultRetries,
+		backoff:      c.defaultBackoff,
+		policy:       c.defaultPolicy,
+		tracer:       c.defaultTracer,
+		traceContext: c.defaultTraceContext,
+		hooks:        append([]Hooks(nil), c.defaultHooks...),
 	}
 
 	for _, option := range options {
 		option(requestOpts)
 	}
+	if requestOpts.context == nil {
+		requestOpts.context = context.Background()
+	}
 
-	var reqBodyReader io.Reader
-	if reqBody != "" {
-		reqBodyReader = strings.NewReader(reqBody)
+	if c.err != nil {
+		onError(requestOpts.hooks, nil, c.err)
+		return nil, nil, c.err
 	}
-	req, err := http.NewRequest(method, url, reqBodyReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to do request: %w", err)
+	if c.closed.Load() {
+		onError(requestOpts.hooks, nil, ErrClosed)
+		return nil, nil, ErrClosed
 	}
 
-	for key, value := range c.defaultHeaders {
-		req.Header.Set(key, value)
-	}
-	for key, value := range requestOpts.headers {
-		req.Header.Set(key, value)
+	call := &call{opts: requestOpts}
+	req, err := http.NewRequestWithContext(withCall(requestOpts.context, call), method, url, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to do request: %w", err)
+		onError(requestOpts.hooks, nil, err)
+		return nil, nil, err
 	}
+	return req, call, nil
+}
 
-	var statusCode int
-	var body []byte
-	for i := 0; i <= requestOpts.retries; i++ {
-		body, statusCode, err = c.doRequest(req, requestOpts.bodyWriter, requestOpts)
+// send makes the request through the built in middlewares, reading the final
+// response.
+func (c *Callout) send(req *http.Request, call *call, url string, reqBody string) (*Response, error) {
+	requestOpts := call.opts
+	var err error
+	if requestOpts.body != nil {
+		call.body, err = requestOpts.body(requestOpts.maxBuffered)
 		if err != nil {
 			return nil, err
 		}
-
-		if statusCode >= 200 && statusCode < 300 {
-			if requestOpts.jsonValue != nil {
-				err = json.Unmarshal(body, requestOpts.jsonValue)
-				if err != nil {
-					return body, fmt.Errorf("failed to unmarshal response: %w", err)
-				}
-			}
-			return body, nil
-		}
-		if statusCode >= 300 && statusCode < 500 {
-			break
-		}
+	} else if reqBody != "" {
+		call.body = bytesBody([]byte(reqBody))
 	}
-
-	return nil, ResponseError{
-		URL:        url,
-		StatusCode: statusCode,
-		Body:       body,
+	if err = c.compressRequestBody(call); err != nil {
+		return nil, err
 	}
-}
 
-func (c *Callout) doRequest(req *http.Request, writer io.Writer, opts *requestOptions) ([]byte, int, error) {
-	if opts.tracer != nil {
-		spanName := opts.spanName
-		if spanName == "" {
-			spanName = req.URL.Path
-		}
-		_, span := opts.tracer.Start(opts.context, spanName)
-		defer span.End()
-	}
-
-	resp, err := c.client.Do(req)
+	start := c.clock.Now()
+	resp, err := c.transport.RoundTrip(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
+	defer c.closeIdleConnectionsIfClosed()
 	defer resp.Body.Close()
 
-	if writer != nil {
-		_, err = io.Copy(writer, resp.Body)
+	var respBody []byte
+	if requestOpts.bodyWriter != nil {
+		_, err = io.Copy(requestOpts.bodyWriter, resp.Body)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to copy body: %w", err)
+			return nil, fmt.Errorf("failed to copy body: %w", err)
 		}
-
-		return nil, resp.StatusCode, nil
 	} else {
-		body, err := ioutil.ReadAll(resp.Body)
+		respBody, err = ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to read body: %w", err)
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+	}
+
+	response := newResponse(resp, respBody)
+	response.Attempts = call.attempts
+	response.Cached = call.cached
+	response.Elapsed = c.clock.Now().Sub(start)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return response, ResponseError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Body:       respBody,
 		}
+	}
 
-		return body, resp.StatusCode, nil
+	if requestOpts.jsonValue != nil {
+		err = json.Unmarshal(respBody, requestOpts.jsonValue)
+		if err != nil {
+			return response, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
 	}
+	return response, nil
+}
+
+// clientRoundTripper sends each attempt through the http.Client, which applies
+// the timeout, follows redirects and runs the middlewares added with
+// WithMiddleware.
+func (c *Callout) clientRoundTripper() http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := c.client.Do(req)
+		if err != nil {
+			var pinErr *PinError
+			if errors.As(err, &pinErr) && pinErr.Host == "" {
+				pinErr.Host = req.URL.Hostname()
+			}
+			return nil, fmt.Errorf("failed to make request: %w", err)
+		}
+		return resp, nil
+	})
 }
@@ -2,18 +2,318 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
 	"time"
 )
 
 type CalloutOption func(*Callout)
 
+// WithClock replaces the clock used to wait between retries.
+func WithClock(clock Clock) CalloutOption {
+	return func(c *Callout) {
+		c.clock = clock
+	}
+}
+
+// WithCircuitBreaker adds a circuit breaker for every host the Callout calls.
+// While the circuit for a host is open, requests to it fail fast with
+// ErrCircuitOpen.
+func WithCircuitBreaker(settings CircuitBreakerSettings) CalloutOption {
+	return func(c *Callout) {
+		c.breakerSettings = &settings
+	}
+}
+
+// WithRateLimit limits all requests made by the Callout. Requests wait for the
+// limit unless it is set to fail fast, and stop waiting when their context is
+// done.
+func WithRateLimit(limit RateLimit) CalloutOption {
+	return func(c *Callout) {
+		c.rateLimiter().global = newTokenBucket(limit)
+	}
+}
+
+// WithHostRateLimit limits the requests made to host, which includes the port
+// when the URL has one. A host of "*" gives every other host its own limit.
+func WithHostRateLimit(host string, limit RateLimit) CalloutOption {
+	return func(c *Callout) {
+		c.rateLimiter().hostLimits[host] = limit
+	}
+}
+
+// WithRouteRateLimit limits the requests whose host and path match pattern,
+// using the syntax of path.Match, such as "api.example.com/v1/orders/*".
+func WithRouteRateLimit(pattern string, limit RateLimit) CalloutOption {
+	return func(c *Callout) {
+		limiter := c.rateLimiter()
+		limiter.routes = append(limiter.routes, routeLimit{
+			pattern: pattern,
+			bucket:  newTokenBucket(limit),
+		})
+	}
+}
+
+// WithProxy sends requests through the proxy at proxyURL. HTTP and HTTPS
+// proxies are given requests for http URLs and tunnel requests for https URLs
+// with CONNECT, and SOCKS5 proxies tunnel every connection. The socks5h
+// scheme has the SOCKS5 proxy resolve host names. Credentials in the URL are
+// sent to the proxy with basic authentication.
+func WithProxy(proxyURL string) CalloutOption {
+	return func(c *Callout) {
+		c.proxy.proxyURL = proxyURL
+	}
+}
+
+// WithProxyFromEnvironment sends requests through the proxies set in the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, or their lower
+// case versions, as they are when the Callout is created. A proxy given with
+// WithProxy takes precedence.
+func WithProxyFromEnvironment() CalloutOption {
+	return func(c *Callout) {
+		c.proxy.fromEnvironment = true
+	}
+}
+
+// WithNoProxy sends requests to hosts matching the rules directly, using the
+// syntax of NO_PROXY: "*" matches every host, "example.com" matches it and its
+// subdomains, ".example.com" only its subdomains, and IP addresses, CIDR
+// ranges and a trailing ":port" are also allowed.
+func WithNoProxy(rules ...string) CalloutOption {
+	return func(c *Callout) {
+		c.proxy.noProxy = append(c.proxy.noProxy, rules...)
+	}
+}
+
+// WithDialTimeout sets how long to wait for a connection to be established.
+// It defaults to 5 seconds.
+func WithDialTimeout(timeout time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.dialTimeout = timeout
+	}
+}
+
+// WithDialKeepAlive sets the interval between TCP keep-alive probes, which
+// defaults to 15 seconds. A negative interval disables them.
+func WithDialKeepAlive(interval time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.dialKeepAlive = interval
+	}
+}
+
+// WithTLSHandshakeTimeout sets how long to wait for a TLS handshake. It
+// defaults to 5 seconds.
+func WithTLSHandshakeTimeout(timeout time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.tlsHandshakeTimeout = timeout
+	}
+}
+
+// WithMaxIdleConns limits the idle connections kept for reuse across all
+// hosts. Zero means no limit, which is the default.
+func WithMaxIdleConns(n int) CalloutOption {
+	return func(c *Callout) {
+		c.connections.maxIdleConns = n
+	}
+}
+
+// WithMaxIdleConnsPerHost limits the idle connections kept for reuse for each
+// host. It defaults to http.DefaultMaxIdleConnsPerHost, which is 2.
+func WithMaxIdleConnsPerHost(n int) CalloutOption {
+	return func(c *Callout) {
+		c.connections.maxIdleConnsPerHost = n
+	}
+}
+
+// WithMaxConnsPerHost limits the connections to each host, including those in
+// use. Requests wait for a connection once the limit is reached. Zero means no
+// limit, which is the default.
+func WithMaxConnsPerHost(n int) CalloutOption {
+	return func(c *Callout) {
+		c.connections.maxConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept for reuse.
+// Zero means no limit, which is the default.
+func WithIdleConnTimeout(timeout time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.idleConnTimeout = timeout
+	}
+}
+
+// WithResponseHeaderTimeout sets how long to wait for the response headers
+// once the request has been written. Zero means no limit other than the
+// request timeout, which is the default.
+func WithResponseHeaderTimeout(timeout time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.responseHeaderTimeout = timeout
+	}
+}
+
+// WithExpectContinueTimeout sets how long to wait for the response headers
+// of a request with an "Expect: 100-continue" header before sending its body.
+// Zero sends the body straight away, which is the default.
+func WithExpectContinueTimeout(timeout time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.connections.expectContinueTimeout = timeout
+	}
+}
+
+// WithKeepAlives sets whether connections are kept open and reused between
+// requests, which they are by default.
+func WithKeepAlives(enabled bool) CalloutOption {
+	return func(c *Callout) {
+		c.connections.disableKeepAlives = !enabled
+	}
+}
+
+// WithCache caches the responses to GET and HEAD requests in cache, as a
+// private cache following RFC 9111. Fresh responses are returned without
+// making a request and stale ones are revalidated with conditional requests,
+// or returned while they are within their stale-while-revalidate or
+// stale-if-error periods. Responses with bodies over 16MiB are not cached, and
+// neither are responses to requests with an Authorization or Cookie header
+// unless they are marked public.
+func WithCache(cache Cache) CalloutOption {
+	return func(c *Callout) {
+		c.cache = &httpCache{store: cache}
+	}
+}
+
+// WithDecompression sets whether gzip and deflate encoded responses are
+// decoded, which they are by default, including when the Accept-Encoding
+// header is set on the request. Without it, responses are only decoded when
+// the transport asked for them itself.
+func WithDecompression(enabled bool) CalloutOption {
+	return func(c *Callout) {
+		c.compression.skipDecompression = !enabled
+	}
+}
+
+// WithRequestCompression gzips request bodies of at least minSize bytes, and
+// bodies whose size is unknown, unless the request sets its own
+// Content-Encoding header. The server must accept gzip encoded requests.
+func WithRequestCompression(minSize int64) CalloutOption {
+	return func(c *Callout) {
+		c.compression.requestMinSize = minSize
+	}
+}
+
+// WithTransport replaces the transport used to send requests, which is an
+// http.Transport by default. The TLS, proxy and connection options have no
+// effect on a replaced transport.
+func WithTransport(transport http.RoundTripper) CalloutOption {
+	return func(c *Callout) {
+		c.baseTransport = transport
+	}
+}
+
+// WithMiddleware wraps the transport in middlewares, the first of which is the
+// outermost. Middlewares run after the built in retry, tracing and header
+// handling, once for every attempt and every redirect.
+func WithMiddleware(middlewares ...Middleware) CalloutOption {
+	return func(c *Callout) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// WithDefaultHooks adds hooks that observe the attempts made for every
+// request.
+func WithDefaultHooks(hooks Hooks) CalloutOption {
+	return func(c *Callout) {
+		c.defaultHooks = append(c.defaultHooks, hooks)
+	}
+}
+
+// WithLogger logs every attempt made by the Callout to logger, redacting
+// sensitive headers, query parameters and JSON fields as set in settings.
+func WithLogger(logger *slog.Logger, settings LogSettings) CalloutOption {
+	return func(c *Callout) {
+		c.logger = newCallLogger(logger, settings)
+	}
+}
+
+// WithMetrics records the requests made by the Callout, along with the events
+// of its circuit breaker and rate limits, to metrics.
+func WithMetrics(metrics Metrics) CalloutOption {
+	return func(c *Callout) {
+		c.metrics = metrics
+	}
+}
+
 func DefaultSkipTLSVerify(skipTLSVerify bool) CalloutOption {
 	return func(c *Callout) {
 		c.skipTLSVerify = skipTLSVerify
 	}
 }
 
+// WithCAFile trusts the CA certificates in the PEM encoded files instead of
+// the system CAs. Requests fail when a file cannot be loaded.
+func WithCAFile(files ...string) CalloutOption {
+	return func(c *Callout) {
+		c.tls.caFiles = append(c.tls.caFiles, files...)
+	}
+}
+
+// WithCAPEM trusts the PEM encoded CA certificates instead of the system CAs.
+func WithCAPEM(pem []byte) CalloutOption {
+	return func(c *Callout) {
+		c.tls.caPEMs = append(c.tls.caPEMs, pem)
+	}
+}
+
+// WithClientCertificateFile presents the certificate in certFile and keyFile
+// to servers that ask for one. The files are loaded again when they change,
+// so rotated certificates are used without creating a new Callout.
+func WithClientCertificateFile(certFile, keyFile string) CalloutOption {
+	return func(c *Callout) {
+		c.tls.certFile = certFile
+		c.tls.keyFile = keyFile
+	}
+}
+
+// WithClientCertificate presents certificate to servers that ask for one.
+func WithClientCertificate(certificate tls.Certificate) CalloutOption {
+	return func(c *Callout) {
+		c.tls.certificates = append(c.tls.certificates, certificate)
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version, such as tls.VersionTLS13.
+func WithMinTLSVersion(version uint16) CalloutOption {
+	return func(c *Callout) {
+		c.tls.minVersion = version
+	}
+}
+
+// WithCipherSuites limits the cipher suites used for TLS 1.2 and earlier.
+func WithCipherSuites(suites ...uint16) CalloutOption {
+	return func(c *Callout) {
+		c.tls.cipherSuites = suites
+	}
+}
+
+// WithPinning only allows TLS connections to servers whose leaf or
+// intermediate certificate has one of the pinned public keys. Connections that
+// do not match fail with a PinError, or are logged to the logger given with
+// WithLogger when settings.ReportOnly is set.
+func WithPinning(settings PinSettings) CalloutOption {
+	return func(c *Callout) {
+		c.pins = &settings
+	}
+}
+
+// WithServerName sets the server name sent with SNI and used to verify the
+// server certificate, instead of the host of the URL.
+func WithServerName(serverName string) CalloutOption {
+	return func(c *Callout) {
+		c.tls.serverName = serverName
+	}
+}
+
 func WithDefaultHeader(name, value string) CalloutOption {
 	return func(c *Callout) {
 		if c.defaultHeaders == nil {
@@ -34,12 +334,45 @@ func WithDefaultHeaders(headers map[string]string) CalloutOption {
 	}
 }
 
+// WithDefaultMaxBufferedBody sets how much of a body given to WithBody is
+// buffered in memory so the request can be retried. It defaults to 1MiB.
+func WithDefaultMaxBufferedBody(limit int64) CalloutOption {
+	return func(c *Callout) {
+		c.defaultMaxBuffered = limit
+	}
+}
+
 func WithDefaultRetries(retries int) CalloutOption {
 	return func(c *Callout) {
 		c.defaultRetries = retries
 	}
 }
 
+// WithDefaultBackoff sets how long to wait between retries. Without a backoff
+// retries are made immediately unless the server sends a Retry-After header.
+func WithDefaultBackoff(backoff Backoff) CalloutOption {
+	return func(c *Callout) {
+		c.defaultBackoff = backoff
+	}
+}
+
+// WithMaxRetryAfter sets the longest wait asked for by a Retry-After header
+// that a retry is made after, which defaults to a minute. A request asked to
+// wait longer than limit is not retried and returns the response it got.
+func WithMaxRetryAfter(limit time.Duration) CalloutOption {
+	return func(c *Callout) {
+		c.maxRetryAfter = limit
+	}
+}
+
+// WithDefaultRetryPolicy sets which failures are retried. DefaultRetryPolicy is
+// used when it is not set.
+func WithDefaultRetryPolicy(policy RetryPolicy) CalloutOption {
+	return func(c *Callout) {
+		c.defaultPolicy = policy
+	}
+}
+
 func WithDefaultTimeout(timeout time.Duration) CalloutOption {
 	return func(c *Callout) {
 		c.defaultTimeout = timeout
@@ -49,6 +382,6 @@ func WithDefaultTimeout(timeout time.Duration) CalloutOption {
 func WithDefaultTracer(tracer trace.Tracer, ctx context.Context) CalloutOption {
 	return func(c *Callout) {
 		c.defaultTracer = tracer
-		c.defaultContext = ctx
+		c.defaultTraceContext = ctx
 	}
 }
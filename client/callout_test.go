@@ -2,6 +2,8 @@ package client_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	. "github.com/onsi/gomega"
 	"github.com/sclevine/spec"
@@ -42,7 +44,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 					w.WriteHeader(http.StatusOK)
 					_, _ = fmt.Fprint(w, value)
-				} else if r.Method == http.MethodPost {
+				} else if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
 					body, err := ioutil.ReadAll(r.Body)
 					if err != nil {
 						w.WriteHeader(http.StatusInternalServerError)
@@ -54,8 +56,11 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					_, _ = fmt.Fprint(w, string(body))
 				} else {
 					w.WriteHeader(http.StatusBadRequest)
-					_, _ = fmt.Fprintf(w, "Method must be GET, HEAD, or POST")
+					_, _ = fmt.Fprintf(w, "Method must be GET, HEAD, POST, PUT, or PATCH")
 				}
+			case "/method":
+				w.WriteHeader(http.StatusOK)
+				_, _ = fmt.Fprint(w, r.Method)
 			case "/sleep":
 				duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
 				if err != nil {
@@ -68,6 +73,17 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				w.WriteHeader(http.StatusOK)
 				_, _ = fmt.Fprintf(w, "slept for %v", duration)
+			case "/created":
+				w.Header().Set("Location", "/widgets/1")
+				w.Header().Set("ETag", `"v1"`)
+				w.WriteHeader(http.StatusCreated)
+				_, _ = fmt.Fprintf(w, "created")
+			case "/redirect":
+				http.Redirect(w, r, "/200", http.StatusFound)
+			case "/trailer":
+				w.Header().Set("Trailer", "X-Checksum")
+				_, _ = fmt.Fprintf(w, "body")
+				w.Header().Set("X-Checksum", "abc")
 			case "/200":
 				w.WriteHeader(http.StatusOK)
 				_, _ = fmt.Fprintf(w, "200")
@@ -160,6 +176,19 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Expect(string(body)).To(ContainSubstring("Header4: value4"))
 				Expect(string(body)).To(ContainSubstring("Header5: value5"))
 			})
+
+			it("does not add request headers to the default headers", func() {
+				callout := client.New(client.WithDefaultHeader("header1", "value1"))
+
+				url := server.URL + "/print-request"
+				_, err := callout.Get(url, client.WithHeader("header2", "value2"))
+				Expect(err).NotTo(HaveOccurred())
+
+				body, err := callout.Get(url)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring("Header1: value1"))
+				Expect(string(body)).NotTo(ContainSubstring("Header2"))
+			})
 		})
 
 		when("WithTimeout", func() {
@@ -234,6 +263,53 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("WithContext", func() {
+			it("aborts the request when the context is cancelled", func() {
+				callout := client.New()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+
+				start := time.Now()
+				url := fmt.Sprintf("%s/sleep?duration=%s", server.URL, 250*time.Millisecond)
+				_, err := callout.Get(url, client.WithContext(ctx))
+
+				Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+				Expect(time.Since(start)).To(BeNumerically("<", 250*time.Millisecond))
+			})
+
+			it("does not make any remaining retries once the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				calls := 0
+				cancellingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls++
+					cancel()
+					w.WriteHeader(http.StatusInternalServerError)
+				}))
+				defer cancellingServer.Close()
+
+				callout := client.New()
+				_, err := callout.Get(cancellingServer.URL, client.WithContext(ctx), client.WithRetries(5))
+
+				Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+				Expect(calls).To(Equal(1))
+			})
+
+			it("returns an error without making a request when the context is already done", func() {
+				callout := client.New()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err := callout.Get(server.URL+"/200", client.WithContext(ctx))
+
+				Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+				Expect(requestCount).To(Equal(0))
+			})
+		})
+
 		when("UnmarshalJSON", func() {
 			it("unmarshals the response body to the given value", func() {
 				callout := client.New()
@@ -354,12 +430,39 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		when("WithRetries", func() {
-			it("retries on a 5XX response until a 2XX response", func() {
+			retryNonIdempotent := client.WithRetryPolicy(client.DefaultRetryPolicy{RetryNonIdempotent: true})
+
+			it("does not retry by default", func() {
 				callout := client.New()
 
 				url := server.URL + "/500forFirstThreeRequestsThen200"
 				body, err := callout.Post(url, "body", client.WithRetries(5))
 
+				Expect(err).To(MatchError(client.ResponseError{
+					URL:        url,
+					StatusCode: 500,
+					Body:       []byte("500 on request 1"),
+				}))
+				Expect(body).To(BeEmpty())
+				Expect(requestCount).To(Equal(1))
+			})
+
+			it("retries when the request has an Idempotency-Key header", func() {
+				callout := client.New()
+
+				url := server.URL + "/500forFirstThreeRequestsThen200"
+				body, err := callout.Post(url, "body", client.WithRetries(5), client.WithHeader("Idempotency-Key", "key"))
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("200 on request 4"))
+			})
+
+			it("retries on a 5XX response until a 2XX response", func() {
+				callout := client.New()
+
+				url := server.URL + "/500forFirstThreeRequestsThen200"
+				body, err := callout.Post(url, "body", client.WithRetries(5), retryNonIdempotent)
+
 				Expect(err).NotTo(HaveOccurred())
 				Expect(string(body)).To(Equal("200 on request 4"))
 			})
@@ -368,7 +471,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				callout := client.New()
 
 				url := server.URL + "/500forFirstThreeRequestsThen200"
-				body, err := callout.Post(url, "body", client.WithRetries(2))
+				body, err := callout.Post(url, "body", client.WithRetries(2), retryNonIdempotent)
 
 				Expect(err).To(MatchError(client.ResponseError{
 					URL:        url,
@@ -394,7 +497,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			})
 
 			it("uses retries set on the callout", func() {
-				callout := client.New(client.WithDefaultRetries(3))
+				callout := client.New(client.WithDefaultRetries(3), client.WithDefaultRetryPolicy(
+					client.DefaultRetryPolicy{RetryNonIdempotent: true},
+				))
 
 				url := server.URL + "/500forFirstThreeRequestsThen200"
 				body, err := callout.Post(url, "body")
@@ -407,7 +512,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				callout := client.New(client.WithDefaultRetries(1))
 
 				url := server.URL + "/500forFirstThreeRequestsThen200"
-				body, err := callout.Post(url, "body", client.WithRetries(3))
+				body, err := callout.Post(url, "body", client.WithRetries(3), retryNonIdempotent)
 
 				Expect(err).NotTo(HaveOccurred())
 				Expect(string(body)).To(Equal("200 on request 4"))
@@ -521,4 +626,220 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 	})
+
+	when("Put", func() {
+		it("returns the response body", func() {
+			callout := client.New()
+
+			url := server.URL + "/echo"
+			body, err := callout.Put(url, "foobar")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+		})
+
+		it("returns a ResponseError when the response is not 200", func() {
+			callout := client.New()
+
+			url := server.URL + "/500"
+			body, err := callout.Put(url, "body")
+
+			Expect(err).To(MatchError(client.ResponseError{
+				URL:        server.URL + "/500",
+				StatusCode: 500,
+				Body:       []byte("500"),
+			}))
+			Expect(body).To(BeEmpty())
+		})
+
+		it("retries on a 5XX response until a 2XX response", func() {
+			callout := client.New()
+
+			url := server.URL + "/500forFirstThreeRequestsThen200"
+			body, err := callout.Put(url, "body", client.WithRetries(5))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 4"))
+		})
+	})
+
+	when("Patch", func() {
+		it("returns the response body", func() {
+			callout := client.New()
+
+			url := server.URL + "/echo"
+			body, err := callout.Patch(url, "foobar")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+		})
+
+		it("returns a ResponseError when the response is not 200", func() {
+			callout := client.New()
+
+			url := server.URL + "/400"
+			body, err := callout.Patch(url, "body")
+
+			Expect(err).To(MatchError(client.ResponseError{
+				URL:        server.URL + "/400",
+				StatusCode: 400,
+				Body:       []byte("400"),
+			}))
+			Expect(body).To(BeEmpty())
+		})
+	})
+
+	when("Delete", func() {
+		it("sends a DELETE request", func() {
+			callout := client.New()
+
+			url := server.URL + "/method"
+			body, err := callout.Delete(url)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal(http.MethodDelete))
+		})
+
+		it("returns a ResponseError when the response is not 200", func() {
+			callout := client.New()
+
+			url := server.URL + "/500"
+			body, err := callout.Delete(url)
+
+			Expect(err).To(MatchError(client.ResponseError{
+				URL:        server.URL + "/500",
+				StatusCode: 500,
+				Body:       []byte("500"),
+			}))
+			Expect(body).To(BeEmpty())
+		})
+
+		it("combines the default headers and request headers", func() {
+			callout := client.New(client.WithDefaultHeader("header1", "value1"))
+
+			url := server.URL + "/print-request"
+			body, err := callout.Delete(url, client.WithHeader("header2", "value2"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(ContainSubstring("DELETE /print-request"))
+			Expect(string(body)).To(ContainSubstring("Header1: value1"))
+			Expect(string(body)).To(ContainSubstring("Header2: value2"))
+		})
+	})
+
+	when("Options", func() {
+		it("sends an OPTIONS request", func() {
+			callout := client.New()
+
+			url := server.URL + "/method"
+			body, err := callout.Options(url)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal(http.MethodOptions))
+		})
+	})
+
+	when("Do", func() {
+		it("sends a request with the given method and body", func() {
+			callout := client.New()
+
+			url := server.URL + "/print-request"
+			body, err := callout.Do("PROPFIND", url, "foobar")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(HavePrefix("PROPFIND /print-request"))
+			Expect(string(body)).To(HaveSuffix("foobar"))
+		})
+
+		it("returns a ResponseError when the response is not 200", func() {
+			callout := client.New()
+
+			url := server.URL + "/500forFirstThreeRequestsThen200"
+			body, err := callout.Do(http.MethodPut, url, "body", client.WithRetries(1))
+
+			Expect(err).To(MatchError(client.ResponseError{
+				URL:        url,
+				StatusCode: 500,
+				Body:       []byte("500 on request 2"),
+			}))
+			Expect(body).To(BeEmpty())
+			Expect(requestCount).To(Equal(2))
+		})
+
+		it("returns an error when the method is invalid", func() {
+			callout := client.New()
+
+			body, err := callout.Do("BAD METHOD", server.URL+"/200", "")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(BeAssignableToTypeOf(client.ResponseError{}))
+			Expect(body).To(BeEmpty())
+		})
+	})
+	when("Send", func() {
+		it("returns the status, headers and body", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodPost, server.URL+"/created", "body")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(resp.Header.Get("Location")).To(Equal("/widgets/1"))
+			Expect(resp.Header.Get("ETag")).To(Equal(`"v1"`))
+			Expect(string(resp.Body)).To(Equal("created"))
+			Expect(resp.Attempts).To(Equal(1))
+			Expect(resp.Elapsed).To(BeNumerically(">", 0))
+		})
+
+		it("returns the trailers", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodGet, server.URL+"/trailer", "")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Trailer.Get("X-Checksum")).To(Equal("abc"))
+		})
+
+		it("returns the final URL after redirects", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodGet, server.URL+"/redirect", "")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.URL.String()).To(Equal(server.URL + "/200"))
+			Expect(string(resp.Body)).To(Equal("200"))
+		})
+
+		it("counts the attempts made", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodGet, server.URL+"/500forFirstThreeRequestsThen200", "", client.WithRetries(5))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Attempts).To(Equal(4))
+		})
+
+		it("returns the response alongside a ResponseError", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodGet, server.URL+"/500", "", client.WithRetries(1))
+
+			Expect(err).To(MatchError(client.ResponseError{
+				URL:        server.URL + "/500",
+				StatusCode: 500,
+				Body:       []byte("500"),
+			}))
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			Expect(resp.Attempts).To(Equal(2))
+		})
+
+		it("returns no response when the request cannot be made", func() {
+			callout := client.New()
+
+			resp, err := callout.Send(http.MethodGet, "this isn't a URL", "")
+
+			Expect(err).To(HaveOccurred())
+			Expect(resp).To(BeNil())
+		})
+	})
 }
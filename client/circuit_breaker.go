@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultCoolDown            = 30 * time.Second
+	defaultHalfOpenProbes      = 1
+)
+
+// CircuitState is the state of the circuit for a host.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerSettings configures the circuit breaker added with
+// WithCircuitBreaker. Attempts that fail to get a response or get a 5XX
+// response count as failures.
+type CircuitBreakerSettings struct {
+	// ConsecutiveFailures opens the circuit after this many failures in a
+	// row. It defaults to 5 when FailureRatio is not set either.
+	ConsecutiveFailures int
+	// FailureRatio opens the circuit when this ratio of attempts fail, once
+	// at least MinRequests attempts have been made.
+	FailureRatio float64
+	MinRequests  int
+	// Interval is how often the counts are cleared while the circuit is
+	// closed. The counts are kept until the circuit opens when it is zero.
+	Interval time.Duration
+	// CoolDown is how long the circuit stays open before letting probes
+	// through. It defaults to 30 seconds.
+	CoolDown time.Duration
+	// HalfOpenProbes is how many attempts are let through while the circuit
+	// is half-open, all of which must succeed to close it. It defaults to 1.
+	HalfOpenProbes int
+	// OnStateChange is called whenever the circuit for a host changes state.
+	OnStateChange func(host string, from, to CircuitState)
+}
+
+// circuitBreaker keeps a separate circuit for every host.
+type circuitBreaker struct {
+	settings CircuitBreakerSettings
+	clock    Clock
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state CircuitState
+	// generation changes with every state change and interval, so results
+	// from attempts let through before then are ignored.
+	generation  uint64
+	expiry      time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+}
+
+type stateChange struct {
+	host     string
+	from, to CircuitState
+}
+
+func newCircuitBreaker(settings CircuitBreakerSettings, clock Clock) *circuitBreaker {
+	if settings.ConsecutiveFailures <= 0 && settings.FailureRatio <= 0 {
+		settings.ConsecutiveFailures = defaultConsecutiveFailures
+	}
+	if settings.CoolDown <= 0 {
+		settings.CoolDown = defaultCoolDown
+	}
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = defaultHalfOpenProbes
+	}
+
+	return &circuitBreaker{
+		settings: settings,
+		clock:    clock,
+		circuits: map[string]*circuit{},
+	}
+}
+
+// allow returns ErrCircuitOpen when an attempt to host must fail fast, and
+// otherwise the generation to pass to record once the attempt is done.
+func (b *circuitBreaker) allow(host string) (uint64, error) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host, &changes)
+	switch c.state {
+	case CircuitOpen:
+		return 0, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if c.probes >= b.settings.HalfOpenProbes {
+			return 0, ErrCircuitOpen
+		}
+		c.probes++
+	}
+	return c.generation, nil
+}
+
+func (b *circuitBreaker) record(host string, generation uint64, failed bool) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host, &changes)
+	if c.generation != generation {
+		return
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		c.requests++
+		if !failed {
+			c.consecutive = 0
+			return
+		}
+		c.failures++
+		c.consecutive++
+		if b.shouldTrip(c) {
+			changes = append(changes, b.setState(host, c, CircuitOpen))
+		}
+	case CircuitHalfOpen:
+		if failed {
+			changes = append(changes, b.setState(host, c, CircuitOpen))
+			return
+		}
+		c.successes++
+		if c.successes >= b.settings.HalfOpenProbes {
+			changes = append(changes, b.setState(host, c, CircuitClosed))
+		}
+	}
+}
+
+// release gives back an attempt that was let through but whose outcome says
+// nothing about the host, such as one cancelled by the caller.
+func (b *circuitBreaker) release(host string, generation uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, ok := b.circuits[host]
+	if ok && c.generation == generation && c.state == CircuitHalfOpen {
+		c.probes--
+	}
+}
+
+// circuit returns the circuit for host, moving it on if its cool down or
+// interval has passed. It must be called with the mutex held.
+func (b *circuitBreaker) circuit(host string, changes *[]stateChange) *circuit {
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.resetCounts(c)
+		b.circuits[host] = c
+		return c
+	}
+
+	now := b.clock.Now()
+	switch c.state {
+	case CircuitOpen:
+		if !now.Before(c.expiry) {
+			*changes = append(*changes, b.setState(host, c, CircuitHalfOpen))
+		}
+	case CircuitClosed:
+		if !c.expiry.IsZero() && !now.Before(c.expiry) {
+			b.resetCounts(c)
+		}
+	}
+	return c
+}
+
+func (b *circuitBreaker) shouldTrip(c *circuit) bool {
+	if b.settings.ConsecutiveFailures > 0 && c.consecutive >= b.settings.ConsecutiveFailures {
+		return true
+	}
+	return b.settings.FailureRatio > 0 && c.requests >= b.settings.MinRequests &&
+		float64(c.failures)/float64(c.requests) >= b.settings.FailureRatio
+}
+
+func (b *circuitBreaker) setState(host string, c *circuit, state CircuitState) stateChange {
+	change := stateChange{host: host, from: c.state, to: state}
+	c.state = state
+	b.resetCounts(c)
+	if state == CircuitOpen {
+		c.expiry = b.clock.Now().Add(b.settings.CoolDown)
+	}
+	return change
+}
+
+func (b *circuitBreaker) resetCounts(c *circuit) {
+	c.generation++
+	c.requests, c.failures, c.consecutive = 0, 0, 0
+	c.probes, c.successes = 0, 0
+	c.expiry = time.Time{}
+	if c.state == CircuitClosed && b.settings.Interval > 0 {
+		c.expiry = b.clock.Now().Add(b.settings.Interval)
+	}
+}
+
+func (b *circuitBreaker) notify(changes []stateChange) {
+	if b.settings.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.settings.OnStateChange(change.host, change.from, change.to)
+	}
+}
+
+// recordAttempt records the outcome of an attempt against the circuit for
+// host. Attempts cancelled by the caller are not counted.
+func (b *circuitBreaker) recordAttempt(host string, generation uint64, statusCode int, err error) {
+	if errors.Is(err, context.Canceled) {
+		b.release(host, generation)
+		return
+	}
+	b.record(host, generation, err != nil || statusCode >= 500)
+}
+
+// circuitBreakerMiddleware fails attempts fast while the circuit for their
+// host is open, and records the outcome of the attempts it lets through.
+func (c *Callout) circuitBreakerMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.breaker == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		generation, err := c.breaker.allow(req.URL.Host)
+		if err != nil {
+			closeRequestBody(req)
+			c.recordRejection(req.URL.Host, err)
+			return nil, fmt.Errorf("failed to make request to %s: %w", req.URL.Host, err)
+		}
+
+		resp, err := next.RoundTrip(req)
+		if errors.Is(err, ErrRateLimited) {
+			c.breaker.release(req.URL.Host, generation)
+			return nil, err
+		}
+
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		c.breaker.recordAttempt(req.URL.Host, generation, statusCode, err)
+		return resp, err
+	})
+}
@@ -0,0 +1,187 @@
+package client_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnitCircuitBreaker(t *testing.T) {
+	spec.Run(t, "Circuit Breaker Test", testCircuitBreaker, spec.Report(report.Terminal{}))
+}
+
+func testCircuitBreaker(t *testing.T, when spec.G, it spec.S) {
+	type change struct {
+		host     string
+		from, to client.CircuitState
+	}
+
+	var (
+		server       *httptest.Server
+		healthy      *httptest.Server
+		clock        *fakeClock
+		requestCount int
+		failing      bool
+		changes      []change
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		clock = newFakeClock()
+		requestCount = 0
+		failing = true
+		changes = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCount++
+			if failing {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		healthy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	})
+
+	it.After(func() {
+		server.Close()
+		healthy.Close()
+	})
+
+	newCallout := func(settings client.CircuitBreakerSettings) *client.Callout {
+		settings.OnStateChange = func(host string, from, to client.CircuitState) {
+			changes = append(changes, change{host, from, to})
+		}
+		return client.New(client.WithClock(clock), client.WithCircuitBreaker(settings))
+	}
+	host := func(s *httptest.Server) string {
+		return strings.TrimPrefix(s.URL, "http://")
+	}
+
+	it("opens after consecutive failures and fails fast", func() {
+		callout := newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 3})
+
+		for i := 0; i < 3; i++ {
+			_, err := callout.Get(server.URL)
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+		}
+
+		_, err := callout.Get(server.URL)
+		Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeTrue())
+		Expect(requestCount).To(Equal(3))
+		Expect(changes).To(Equal([]change{{host(server), client.CircuitClosed, client.CircuitOpen}}))
+	})
+
+	it("stops retrying once the circuit opens", func() {
+		callout := newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 2})
+
+		_, err := callout.Get(server.URL, client.WithRetries(5))
+
+		Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeTrue())
+		Expect(requestCount).To(Equal(2))
+	})
+
+	it("keeps a separate circuit for every host", func() {
+		callout := newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 1})
+
+		_, _ = callout.Get(server.URL)
+		_, err := callout.Get(server.URL)
+		Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeTrue())
+
+		_, err = callout.Get(healthy.URL)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("resets the consecutive failures after a success", func() {
+		callout := newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 2})
+
+		_, _ = callout.Get(server.URL)
+		failing = false
+		_, _ = callout.Get(server.URL)
+		failing = true
+		_, _ = callout.Get(server.URL)
+
+		_, err := callout.Get(server.URL)
+		Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeFalse())
+	})
+
+	it("opens when the failure ratio is reached after the minimum requests", func() {
+		callout := newCallout(client.CircuitBreakerSettings{FailureRatio: 0.5, MinRequests: 4})
+
+		failing = false
+		_, _ = callout.Get(server.URL)
+		_, _ = callout.Get(server.URL)
+		failing = true
+		_, _ = callout.Get(server.URL)
+		Expect(changes).To(BeEmpty())
+
+		_, _ = callout.Get(server.URL)
+		Expect(changes).To(Equal([]change{{host(server), client.CircuitClosed, client.CircuitOpen}}))
+	})
+
+	it("clears the counts every interval", func() {
+		callout := newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 2, Interval: time.Minute})
+
+		_, _ = callout.Get(server.URL)
+		clock.Advance(time.Minute)
+		_, _ = callout.Get(server.URL)
+		Expect(changes).To(BeEmpty())
+
+		_, _ = callout.Get(server.URL)
+		Expect(changes).To(HaveLen(1))
+	})
+
+	when("the cool down has passed", func() {
+		var callout *client.Callout
+
+		it.Before(func() {
+			callout = newCallout(client.CircuitBreakerSettings{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			_, _ = callout.Get(server.URL)
+
+			clock.Advance(59 * time.Second)
+			_, err := callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeTrue())
+			clock.Advance(time.Second)
+		})
+
+		it("closes the circuit when the probe succeeds", func() {
+			failing = false
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(changes).To(Equal([]change{
+				{host(server), client.CircuitClosed, client.CircuitOpen},
+				{host(server), client.CircuitOpen, client.CircuitHalfOpen},
+				{host(server), client.CircuitHalfOpen, client.CircuitClosed},
+			}))
+		})
+
+		it("opens the circuit again when the probe fails", func() {
+			_, err := callout.Get(server.URL)
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+
+			_, err = callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrCircuitOpen)).To(BeTrue())
+
+			Expect(changes).To(Equal([]change{
+				{host(server), client.CircuitClosed, client.CircuitOpen},
+				{host(server), client.CircuitOpen, client.CircuitHalfOpen},
+				{host(server), client.CircuitHalfOpen, client.CircuitOpen},
+			}))
+		})
+	})
+
+	it("describes its states", func() {
+		Expect(client.CircuitClosed.String()).To(Equal("closed"))
+		Expect(client.CircuitOpen.String()).To(Equal("open"))
+		Expect(client.CircuitHalfOpen.String()).To(Equal("half-open"))
+	})
+}
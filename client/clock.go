@@ -0,0 +1,20 @@
+package client
+
+import "time"
+
+// Clock is the source of time used when waiting between retries. It can be
+// replaced with WithClock so tests can verify delays without sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
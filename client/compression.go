@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// compressionSettings holds the content encoding options given to a Callout.
+type compressionSettings struct {
+	skipDecompression bool
+	requestMinSize    int64
+}
+
+// decompressMiddleware asks for compressed responses when the request does
+// not say which encodings it accepts, and decodes gzip and deflate encoded
+// responses whether or not it asked for them.
+func (c *Callout) decompressMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.compression.skipDecompression {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != http.MethodHead {
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil || req.Method == http.MethodHead {
+			return resp, err
+		}
+
+		encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+		switch encoding {
+		case "gzip", "x-gzip", "deflate":
+			resp.Body = &decodingBody{body: resp.Body, encoding: encoding}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			resp.Uncompressed = true
+		}
+		return resp, nil
+	})
+}
+
+// decodingBody decodes a response body, reading the header of the encoding on
+// the first read so that empty bodies are not treated as corrupt.
+type decodingBody struct {
+	body     io.ReadCloser
+	encoding string
+	reader   io.Reader
+	err      error
+}
+
+func (d *decodingBody) Read(p []byte) (int, error) {
+	if d.reader == nil && d.err == nil {
+		d.reader, d.err = newDecoder(d.body, d.encoding)
+		if d.err != nil {
+			d.err = fmt.Errorf("failed to decode %s body: %w", d.encoding, d.err)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.reader.Read(p)
+}
+
+func (d *decodingBody) Close() error {
+	return d.body.Close()
+}
+
+// newDecoder returns a reader decoding r. Deflate is meant to be sent in the
+// zlib format, but some servers send raw deflate data, so both are accepted.
+func newDecoder(r io.Reader, encoding string) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		return buffered, nil
+	}
+
+	if encoding != "deflate" {
+		return gzip.NewReader(buffered)
+	}
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// compressRequestBody gzips the body of a call when it is at least the
+// minimum size set with WithRequestCompression, or its size is unknown,
+// unless the request already sets its Content-Encoding.
+func (c *Callout) compressRequestBody(call *call) error {
+	body := call.body
+	if c.compression.requestMinSize <= 0 || body == nil || (body.length >= 0 && body.length < c.compression.requestMinSize) {
+		return nil
+	}
+	for _, headers := range []map[string]string{c.defaultHeaders, body.headers, call.opts.headers} {
+		for name := range headers {
+			if http.CanonicalHeaderKey(name) == "Content-Encoding" {
+				return nil
+			}
+		}
+	}
+
+	headers := map[string]string{"Content-Encoding": "gzip"}
+	for name, value := range body.headers {
+		headers[name] = value
+	}
+
+	if body.replayable && body.length >= 0 && body.length <= call.opts.maxBuffered {
+		compressed, err := gzipBytes(body)
+		if err != nil {
+			return err
+		}
+		call.body = bytesBody(compressed)
+		call.body.headers = headers
+		return nil
+	}
+
+	open := body.open
+	call.body = &requestBody{
+		open: func() (io.ReadCloser, error) {
+			reader, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return gzipStream(reader), nil
+		},
+		length:     -1,
+		replayable: body.replayable,
+		headers:    headers,
+	}
+	return nil
+}
+
+// gzipBytes compresses a body small enough to be buffered, so that it is sent
+// with a Content-Length.
+func gzipBytes(body *requestBody) ([]byte, error) {
+	reader, err := body.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open request body: %w", err)
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err = io.Copy(writer, reader); err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress request body: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipStream compresses reader as it is read, without buffering it.
+func gzipStream(reader io.ReadCloser) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		writer := gzip.NewWriter(pipeWriter)
+		_, err := io.Copy(writer, reader)
+		if err == nil {
+			err = writer.Close()
+		}
+		_ = reader.Close()
+		_ = pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
@@ -0,0 +1,184 @@
+package client_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnitCompression(t *testing.T) {
+	spec.Run(t, "Compression Test", testCompression, spec.Report(report.Terminal{}))
+}
+
+func testCompression(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server         *httptest.Server
+		encoding       string
+		acceptEncoding string
+	)
+
+	encode := func(body string) []byte {
+		var buf bytes.Buffer
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(&buf)
+		case "deflate":
+			writer = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			writer, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		_, _ = writer.Write([]byte(body))
+		_ = writer.Close()
+		return buf.Bytes()
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		encoding = "gzip"
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			if r.URL.Path == "/empty" {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Encoding", strings.TrimPrefix(encoding, "raw-"))
+			_, _ = w.Write(encode(`{"name":"widget"}`))
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("decoding responses", func() {
+		it("asks for and decodes compressed responses", func() {
+			body, err := client.New().Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal(`{"name":"widget"}`))
+			Expect(acceptEncoding).To(Equal("gzip, deflate"))
+		})
+
+		it("decodes responses when Accept-Encoding is set on the request", func() {
+			response, err := client.New().Send(http.MethodGet, server.URL, "", client.WithHeader("Accept-Encoding", "gzip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(response.Body)).To(Equal(`{"name":"widget"}`))
+			Expect(response.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(acceptEncoding).To(Equal("gzip"))
+		})
+
+		it("decodes deflate responses in the zlib and raw formats", func() {
+			for _, encoding = range []string{"deflate", "raw-deflate"} {
+				body, err := client.New().Get(server.URL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal(`{"name":"widget"}`))
+			}
+		})
+
+		it("decodes bodies given to WriteBody and UnmarshalJSONBody", func() {
+			var buf bytes.Buffer
+			_, err := client.New().Get(server.URL, client.WriteBody(&buf), client.WithHeader("Accept-Encoding", "gzip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(Equal(`{"name":"widget"}`))
+
+			var widget struct {
+				Name string `json:"name"`
+			}
+			_, err = client.New().Get(server.URL, client.UnmarshalJSONBody(&widget), client.WithHeader("Accept-Encoding", "gzip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(widget.Name).To(Equal("widget"))
+		})
+
+		it("allows empty encoded bodies", func() {
+			_, err := client.New().Get(server.URL + "/empty")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("returns the encoded body when decompression is disabled", func() {
+			body, err := client.New(client.WithDecompression(false)).Get(server.URL, client.WithHeader("Accept-Encoding", "gzip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(Equal(encode(`{"name":"widget"}`)))
+		})
+	})
+
+	when("WithRequestCompression", func() {
+		var (
+			received        []string
+			contentEncoding []string
+			contentLength   []int64
+		)
+
+		it.Before(func() {
+			received, contentEncoding, contentLength = nil, nil, nil
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentEncoding = append(contentEncoding, r.Header.Get("Content-Encoding"))
+				contentLength = append(contentLength, r.ContentLength)
+				var reader io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					var err error
+					reader, err = gzip.NewReader(r.Body)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				body, err := io.ReadAll(reader)
+				Expect(err).NotTo(HaveOccurred())
+				received = append(received, string(body))
+			})
+		})
+
+		it("compresses bodies of at least the minimum size", func() {
+			callout := client.New(client.WithRequestCompression(100))
+			large := strings.Repeat("bulk ingest ", 100)
+
+			_, err := callout.Post(server.URL, "small")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = callout.Post(server.URL, large)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(received).To(Equal([]string{"small", large}))
+			Expect(contentEncoding).To(Equal([]string{"", "gzip"}))
+			Expect(contentLength[1]).To(BeNumerically("<", len(large)))
+		})
+
+		it("streams bodies that are not buffered and compresses them again when retrying", func() {
+			attempts := 0
+			handler := server.Config.Handler
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTP(w, r)
+				if attempts++; attempts == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+			callout := client.New(client.WithRequestCompression(10))
+			large := strings.Repeat("streamed ", 1000)
+
+			_, err := callout.Put(server.URL, "", client.WithRetries(1), client.WithBodyFunc(func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(large)), nil
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received).To(Equal([]string{large, large}))
+			Expect(contentEncoding).To(Equal([]string{"gzip", "gzip"}))
+			Expect(contentLength).To(Equal([]int64{-1, -1}))
+		})
+
+		it("leaves bodies with their own Content-Encoding alone", func() {
+			callout := client.New(client.WithRequestCompression(1))
+
+			_, err := callout.Post(server.URL, "already encoded", client.WithHeader("content-encoding", "identity"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received).To(Equal([]string{"already encoded"}))
+			Expect(contentEncoding).To(Equal([]string{"identity"}))
+		})
+	})
+}
@@ -0,0 +1,440 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxResumes       = 5
+	defaultProgressInterval = time.Second
+)
+
+// ErrDigestMismatch is returned when a downloaded file does not match its
+// expected SHA-256 hash.
+var ErrDigestMismatch = errors.New("downloaded file does not match its digest")
+
+// DownloadSettings configures a download made with Download or
+// DownloadParallel.
+type DownloadSettings struct {
+	// MaxResumes is how many times an interrupted download, or each range of
+	// a parallel download, is resumed. It defaults to 5, and a negative value
+	// disables resuming.
+	MaxResumes int
+	// SHA256 is the hex encoded SHA-256 hash the file must have. A sha-256
+	// Repr-Digest header, or Content-Digest header on a response with the
+	// whole file, is checked as well.
+	SHA256 string
+	// OnProgress is called as the file is downloaded, at most once every
+	// ProgressInterval, which defaults to a second, and once it is complete.
+	OnProgress       func(progress DownloadProgress)
+	ProgressInterval time.Duration
+	// Concurrency is how many ranges DownloadParallel fetches at once. It
+	// defaults to 4.
+	Concurrency int
+	// ChunkSize is the size of the ranges fetched by DownloadParallel. It
+	// defaults to the size of the file divided by Concurrency.
+	ChunkSize int64
+}
+
+// DownloadProgress reports how far a download has got.
+type DownloadProgress struct {
+	// Bytes is how much of the file has been written.
+	Bytes int64
+	// Total is the size of the file, or -1 when the server did not send it.
+	Total int64
+	// Rate is the average number of bytes received per second since the
+	// download started.
+	Rate float64
+}
+
+// Download makes a GET request for url and writes the body to the file at
+// path. The body is written to a temporary file next to path, which replaces
+// path once the download is complete and its size and digest are verified.
+// An interrupted download is resumed with a Range request, using If-Range so
+// that a file that has changed is downloaded again from the start. Servers
+// that ignore the range have the file downloaded again from the start too.
+//
+// The timeout set with WithDefaultTimeout applies to each request, including
+// reading its body. The returned Response has no Body, and its Attempts count
+// the requests made for every resume.
+func (c *Callout) Download(url, path string, settings DownloadSettings, options ...RequestOption) (*Response, error) {
+	return writeAtomically(path, func(file *os.File) (*Response, error) {
+		d := &download{
+			callout:  c,
+			url:      url,
+			file:     file,
+			settings: settings,
+			options:  options,
+			hash:     sha256.New(),
+			total:    -1,
+			progress: newProgressReporter(settings, c.clock),
+		}
+		return d.run()
+	})
+}
+
+// writeAtomically calls write with a temporary file next to path, which
+// replaces path when write succeeds and is removed otherwise. The file keeps
+// the mode of the file it replaces, and is synced before it replaces it so
+// that a crash cannot leave it partly written.
+func writeAtomically(path string, write func(file *os.File) (*Response, error)) (*Response, error) {
+	file, err := createTemp(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download file: %w", err)
+	}
+
+	response, err := write(file)
+	if err == nil {
+		err = finishTemp(file, path)
+	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write download file: %w", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return response, err
+	}
+	return response, nil
+}
+
+// createTemp creates an empty file next to path, with the mode a new file at
+// path would be given.
+func createTemp(path string) (*os.File, error) {
+	for {
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.part", filepath.Base(path), rand.Uint32()))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if !os.IsExist(err) {
+			return file, err
+		}
+	}
+}
+
+// finishTemp gives a written temporary file the mode of the file at path it
+// replaces, and syncs it to disk.
+func finishTemp(file *os.File, path string) error {
+	if info, err := os.Stat(path); err == nil {
+		if err = file.Chmod(info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to set download file mode: %w", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write download file: %w", err)
+	}
+	return nil
+}
+
+// download is the state of a download across its resumes.
+type download struct {
+	callout  *Callout
+	url      string
+	file     *os.File
+	settings DownloadSettings
+	options  []RequestOption
+	response *Response
+	attempts int
+
+	hash      hash.Hash
+	offset    int64
+	total     int64
+	validator string
+	digest    string
+	writeErr  error
+	progress  *progressReporter
+}
+
+func (d *download) run() (*Response, error) {
+	maxResumes := d.settings.MaxResumes
+	if maxResumes == 0 {
+		maxResumes = defaultMaxResumes
+	}
+
+	for resumes := 0; ; resumes++ {
+		resumable, err := d.fetch()
+		if err == nil {
+			break
+		}
+		if !resumable || resumes >= maxResumes {
+			return d.response, err
+		}
+	}
+
+	if err := d.verify(); err != nil {
+		return d.response, err
+	}
+	d.progress.report(d.offset, d.total, 0, true)
+	return d.response, nil
+}
+
+// fetch requests the rest of the file and writes it, reporting whether a
+// failure can be resumed.
+func (d *download) fetch() (bool, error) {
+	if d.offset > 0 && d.validator == "" {
+		if err := d.restart(); err != nil {
+			return false, err
+		}
+	}
+
+	options := append([]RequestOption{WithHeader("Accept-Encoding", "identity")}, d.options...)
+	if d.offset > 0 {
+		options = append(options, WithHeaders(map[string]string{
+			"Range":    fmt.Sprintf("bytes=%d-", d.offset),
+			"If-Range": d.validator,
+		}))
+	}
+
+	req, call, err := d.callout.newRequest(http.MethodGet, d.url, options)
+	if err != nil {
+		return false, err
+	}
+	call.stream = true
+
+	resumable, err := d.write(req, call)
+	if err != nil {
+		onError(call.opts.hooks, req, err)
+	}
+	return resumable, err
+}
+
+func (d *download) write(req *http.Request, call *call) (bool, error) {
+	resp, err := d.callout.transport.RoundTrip(req)
+	d.attempts += call.attempts
+	if err != nil {
+		return resumable(req.Context(), err), err
+	}
+	defer resp.Body.Close()
+
+	d.response = newResponse(resp, nil)
+	d.response.Attempts = d.attempts
+	d.response.Elapsed = d.callout.clock.Now().Sub(d.progress.start)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err = d.restart(); err != nil {
+			return false, err
+		}
+		d.total = resp.ContentLength
+		d.validator = validator(resp.Header)
+		d.digest = expectedDigest(resp.Header, true)
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != d.offset {
+			if err = d.restart(); err != nil {
+				return false, err
+			}
+			return true, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), d.offset)
+		}
+		d.total = total
+		if digest := expectedDigest(resp.Header, false); digest != "" {
+			d.digest = digest
+		}
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		err = ResponseError{
+			URL:        d.url,
+			StatusCode: resp.StatusCode,
+			Body:       body,
+		}
+		return resumable(req.Context(), err), err
+	}
+
+	if _, err = io.Copy(d, resp.Body); err != nil {
+		if d.writeErr != nil {
+			return false, fmt.Errorf("failed to write download file: %w", d.writeErr)
+		}
+		return resumable(req.Context(), err), fmt.Errorf("failed to copy body: %w", err)
+	}
+	if d.total >= 0 && d.offset < d.total {
+		return true, fmt.Errorf("failed to copy body: %w", io.ErrUnexpectedEOF)
+	}
+	return false, nil
+}
+
+// Write appends to the file, hashing what is written and reporting progress.
+func (d *download) Write(p []byte) (int, error) {
+	n, err := d.file.Write(p)
+	d.hash.Write(p[:n])
+	d.offset += int64(n)
+	if err != nil {
+		d.writeErr = err
+		return n, err
+	}
+	d.progress.report(d.offset, d.total, int64(n), false)
+	return n, nil
+}
+
+// restart empties the file to download it again from the start.
+func (d *download) restart() error {
+	if err := d.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write download file: %w", err)
+	}
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to write download file: %w", err)
+	}
+	d.hash.Reset()
+	d.offset = 0
+	d.total = -1
+	d.validator = ""
+	d.digest = ""
+	return nil
+}
+
+// resumable reports whether a failed request is worth resuming, which it is
+// unless its context is done, a circuit breaker or rate limit rejected it, or
+// the server answered with a status that is not temporary.
+func resumable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrClosed) {
+		return false
+	}
+	var respErr ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return respErr.StatusCode >= 500
+	}
+	return true
+}
+
+func (d *download) verify() error {
+	if d.total >= 0 && d.offset != d.total {
+		return fmt.Errorf("downloaded %d bytes of %s, expected %d", d.offset, d.url, d.total)
+	}
+	return verifyDigest(d.hash.Sum(nil), d.settings.SHA256, d.digest)
+}
+
+// verifyDigest checks the SHA-256 hash of a file against the hex encoded hash
+// given in the settings and the base64 encoded digest sent by the server.
+func verifyDigest(sum []byte, expected string, digest string) error {
+	if expected != "" && !strings.EqualFold(hex.EncodeToString(sum), expected) {
+		return fmt.Errorf("%w: expected SHA-256 %s, got %x", ErrDigestMismatch, expected, sum)
+	}
+	if digest != "" && digest != base64.StdEncoding.EncodeToString(sum) {
+		return fmt.Errorf("%w: expected sha-256 digest %s, got %s", ErrDigestMismatch, digest, base64.StdEncoding.EncodeToString(sum))
+	}
+	return nil
+}
+
+// progressReporter calls OnProgress at most once per interval, and is safe
+// for concurrent use.
+type progressReporter struct {
+	mutex        sync.Mutex
+	onProgress   func(progress DownloadProgress)
+	interval     time.Duration
+	clock        Clock
+	start        time.Time
+	lastProgress time.Time
+	received     int64
+}
+
+func newProgressReporter(settings DownloadSettings, clock Clock) *progressReporter {
+	interval := settings.ProgressInterval
+	if interval == 0 {
+		interval = defaultProgressInterval
+	}
+	return &progressReporter{
+		onProgress: settings.OnProgress,
+		interval:   interval,
+		clock:      clock,
+		start:      clock.Now(),
+	}
+}
+
+// report adds received to the bytes that have arrived, now that bytes of
+// total have been written.
+func (r *progressReporter) report(bytes, total, received int64, done bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.received += received
+	if r.onProgress == nil {
+		return
+	}
+	now := r.clock.Now()
+	if !done && now.Sub(r.lastProgress) < r.interval {
+		return
+	}
+	r.lastProgress = now
+
+	progress := DownloadProgress{Bytes: bytes, Total: total}
+	if elapsed := now.Sub(r.start); elapsed > 0 {
+		progress.Rate = float64(r.received) / elapsed.Seconds()
+	}
+	r.onProgress(progress)
+}
+
+// validator returns the strong ETag or the Last-Modified date of a response,
+// to send as If-Range when resuming.
+func validator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// expectedDigest returns the base64 encoded sha-256 digest from the
+// Repr-Digest header, or from the Content-Digest header when the response has
+// the whole file, as described in RFC 9530.
+func expectedDigest(header http.Header, whole bool) string {
+	names := []string{"Repr-Digest"}
+	if whole {
+		names = append(names, "Content-Digest")
+	}
+	for _, name := range names {
+		for _, member := range strings.Split(headerValue(header, name), ",") {
+			algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if ok && strings.EqualFold(algorithm, "sha-256") {
+				return strings.Trim(value, ":")
+			}
+		}
+	}
+	return ""
+}
+
+// parseContentRange parses a Content-Range header such as "bytes 100-199/1000",
+// returning a total of -1 when it is given as "*".
+func parseContentRange(value string) (int64, int64, error) {
+	rangeSpec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	positions, size, ok := strings.Cut(rangeSpec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	first, _, ok := strings.Cut(positions, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	total := int64(-1)
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+		}
+	}
+	return start, total, nil
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultConcurrency = 4
+
+// errRangesIgnored is returned when a server answers a range request with the
+// whole file, so the download falls back to a single stream.
+var errRangesIgnored = errors.New("server ignored the range request")
+
+// DownloadParallel downloads url to the file at path like Download, fetching
+// ranges of the file concurrently. A HEAD request finds the size of the file
+// and whether the server supports ranges, and the file is created at its full
+// size before the ranges are written into it. Each range that fails is resumed
+// on its own, and the download fails once a range runs out of resumes. Servers
+// that do not support ranges, do not send the size of the file or a strong
+// ETag or Last-Modified header to check that the ranges come from the same
+// version of it, or answer a range request with the whole file have it
+// downloaded with Download instead,
+// as do servers that answer the HEAD request with 405 or 501. Other failures
+// of the HEAD request are returned.
+func (c *Callout) DownloadParallel(url, path string, settings DownloadSettings, options ...RequestOption) (*Response, error) {
+	progress := newProgressReporter(settings, c.clock)
+	identity := append([]RequestOption{WithHeader("Accept-Encoding", "identity")}, options...)
+
+	head, err := c.Send(http.MethodHead, url, "", identity...)
+	if err != nil {
+		if headUnsupported(err) {
+			return c.Download(url, path, settings, options...)
+		}
+		return head, err
+	}
+	size, err := strconv.ParseInt(head.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size <= 0 || !acceptsRanges(head.Header) || validator(head.Header) == "" {
+		return c.Download(url, path, settings, options...)
+	}
+
+	response, err := writeAtomically(path, func(file *os.File) (*Response, error) {
+		p := &parallelDownload{
+			callout:   c,
+			url:       url,
+			file:      file,
+			settings:  settings,
+			options:   identity,
+			head:      head,
+			size:      size,
+			validator: validator(head.Header),
+			progress:  progress,
+		}
+		return p.run(c.requestContext(options))
+	})
+	if errors.Is(err, errRangesIgnored) {
+		return c.Download(url, path, settings, options...)
+	}
+	return response, err
+}
+
+// requestContext returns the context the options give a request.
+func (c *Callout) requestContext(options []RequestOption) context.Context {
+	requestOpts := &requestOptions{}
+	for _, option := range options {
+		option(requestOpts)
+	}
+	if requestOpts.context == nil {
+		return context.Background()
+	}
+	return requestOpts.context
+}
+
+// headUnsupported reports whether a HEAD request failed because the server
+// does not support HEAD requests for the URL.
+func headUnsupported(err error) bool {
+	var respErr ResponseError
+	return errors.As(err, &respErr) &&
+		(respErr.StatusCode == http.StatusMethodNotAllowed || respErr.StatusCode == http.StatusNotImplemented)
+}
+
+func acceptsRanges(header http.Header) bool {
+	for _, unit := range strings.Split(header.Get("Accept-Ranges"), ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
+
+// parallelDownload is the state of a download made with DownloadParallel.
+type parallelDownload struct {
+	callout   *Callout
+	url       string
+	file      *os.File
+	settings  DownloadSettings
+	options   []RequestOption
+	head      *Response
+	size      int64
+	validator string
+	progress  *progressReporter
+
+	written  atomic.Int64
+	attempts atomic.Int64
+}
+
+// chunk is a range of the file, from start to end inclusive, of which written
+// bytes have been written so far.
+type chunk struct {
+	start   int64
+	end     int64
+	written int64
+}
+
+func (p *parallelDownload) run(ctx context.Context) (*Response, error) {
+	if err := p.file.Truncate(p.size); err != nil {
+		return nil, fmt.Errorf("failed to write download file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := p.settings.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	chunkSize := p.settings.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = (p.size + int64(concurrency) - 1) / int64(concurrency)
+	}
+
+	chunks := make(chan *chunk)
+	go func() {
+		defer close(chunks)
+		for start := int64(0); start < p.size; start += chunkSize {
+			end := start + chunkSize - 1
+			if end >= p.size {
+				end = p.size - 1
+			}
+			select {
+			case chunks <- &chunk{start: start, end: end}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ch := range chunks {
+				if err := p.fetchChunk(ctx, ch); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	response := *p.head
+	response.Attempts += int(p.attempts.Load())
+	response.Elapsed = p.callout.clock.Now().Sub(p.progress.start)
+	if firstErr != nil {
+		return &response, firstErr
+	}
+
+	if err := p.verify(); err != nil {
+		return &response, err
+	}
+	p.progress.report(p.size, p.size, 0, true)
+	return &response, nil
+}
+
+// fetchChunk fetches a range, resuming it from where it stopped when it
+// fails.
+func (p *parallelDownload) fetchChunk(ctx context.Context, ch *chunk) error {
+	maxResumes := p.settings.MaxResumes
+	if maxResumes == 0 {
+		maxResumes = defaultMaxResumes
+	}
+
+	for resumes := 0; ; resumes++ {
+		resumable, err := p.fetchRange(ctx, ch)
+		if err == nil || !resumable || resumes >= maxResumes {
+			return err
+		}
+	}
+}
+
+func (p *parallelDownload) fetchRange(ctx context.Context, ch *chunk) (bool, error) {
+	headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", ch.start+ch.written, ch.end)}
+	if p.validator != "" {
+		headers["If-Range"] = p.validator
+	}
+	options := append(append([]RequestOption(nil), p.options...), WithHeaders(headers), WithContext(ctx))
+
+	req, call, err := p.callout.newRequest(http.MethodGet, p.url, options)
+	if err != nil {
+		return false, err
+	}
+	call.stream = true
+
+	resumable, err := p.writeRange(req, call, ch)
+	if err != nil && !errors.Is(err, errRangesIgnored) {
+		onError(call.opts.hooks, req, err)
+	}
+	return resumable, err
+}
+
+func (p *parallelDownload) writeRange(req *http.Request, call *call, ch *chunk) (bool, error) {
+	resp, err := p.callout.transport.RoundTrip(req)
+	p.attempts.Add(int64(call.attempts))
+	if err != nil {
+		return resumable(req.Context(), err), err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return false, errRangesIgnored
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		err = ResponseError{
+			URL:        p.url,
+			StatusCode: resp.StatusCode,
+			Body:       body,
+		}
+		return resumable(req.Context(), err), err
+	}
+
+	offset := ch.start + ch.written
+	if start, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || start != offset {
+		return true, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+	}
+
+	writer := &chunkWriter{download: p, chunk: ch}
+	if _, err = io.Copy(writer, io.LimitReader(resp.Body, ch.end+1-offset)); err != nil {
+		if writer.err != nil {
+			return false, fmt.Errorf("failed to write download file: %w", writer.err)
+		}
+		return resumable(req.Context(), err), fmt.Errorf("failed to copy body: %w", err)
+	}
+	if ch.start+ch.written <= ch.end {
+		return true, fmt.Errorf("failed to copy body: %w", io.ErrUnexpectedEOF)
+	}
+	return false, nil
+}
+
+// verify checks the digest of the file once every range is written, when
+// one is expected.
+func (p *parallelDownload) verify() error {
+	digest := expectedDigest(p.head.Header, true)
+	if p.settings.SHA256 == "" && digest == "" {
+		return nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(p.file, 0, p.size)); err != nil {
+		return fmt.Errorf("failed to read download file: %w", err)
+	}
+	return verifyDigest(hash.Sum(nil), p.settings.SHA256, digest)
+}
+
+// chunkWriter writes a range into its place in the file.
+type chunkWriter struct {
+	download *parallelDownload
+	chunk    *chunk
+	err      error
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	n, err := w.download.file.WriteAt(b, w.chunk.start+w.chunk.written)
+	w.chunk.written += int64(n)
+	if err != nil {
+		w.err = err
+		return n, err
+	}
+	written := w.download.written.Add(int64(n))
+	w.download.progress.report(written, w.download.size, int64(n), false)
+	return n, nil
+}
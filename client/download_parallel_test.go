@@ -0,0 +1,267 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitDownloadParallel(t *testing.T) {
+	spec.Run(t, "Download Parallel Test", testDownloadParallel, spec.Report(report.Terminal{}))
+}
+
+func testDownloadParallel(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server      *httptest.Server
+		mutex       sync.Mutex
+		methods     []string
+		ranges      []string
+		inFlight    int
+		maxInFlight int
+		content     []byte
+		handler     func(w http.ResponseWriter, r *http.Request)
+		dir         string
+		path        string
+	)
+
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}
+
+	sortedRanges := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sorted := append([]string(nil), ranges...)
+		sort.Strings(sorted)
+		return sorted
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		methods, ranges, inFlight, maxInFlight = nil, nil, 0, 0
+		content = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+		handler = serveContent
+		dir = t.TempDir()
+		path = filepath.Join(dir, "artifact.bin")
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			methods = append(methods, r.Method)
+			if r.Method == http.MethodGet {
+				ranges = append(ranges, r.Header.Get("Range"))
+			}
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mutex.Unlock()
+
+			if r.Method == http.MethodGet {
+				time.Sleep(20 * time.Millisecond)
+			}
+			handler(w, r)
+
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+		}))
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.Start()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	expectOnlyFile := func() {
+		written, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(content))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	}
+
+	it("fetches the ranges of the file concurrently", func() {
+		var progress []client.DownloadProgress
+		response, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{
+			OnProgress: func(p client.DownloadProgress) {
+				progress = append(progress, p)
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Attempts).To(Equal(5))
+		expectOnlyFile()
+
+		Expect(methods[0]).To(Equal(http.MethodHead))
+		Expect(sortedRanges()).To(Equal([]string{"bytes=0-16383", "bytes=16384-32767", "bytes=32768-49151", "bytes=49152-65535"}))
+		Expect(maxInFlight).To(BeNumerically(">", 1))
+		Expect(progress[len(progress)-1]).To(Equal(client.DownloadProgress{
+			Bytes: int64(len(content)),
+			Total: int64(len(content)),
+			Rate:  progress[len(progress)-1].Rate,
+		}))
+	})
+
+	it("splits the file into ranges of the chunk size", func() {
+		_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{Concurrency: 2, ChunkSize: 20000})
+		Expect(err).NotTo(HaveOccurred())
+		expectOnlyFile()
+
+		Expect(sortedRanges()).To(Equal([]string{"bytes=0-19999", "bytes=20000-39999", "bytes=40000-59999", "bytes=60000-65535"}))
+		Expect(maxInFlight).To(BeNumerically("<=", 2))
+	})
+
+	it("resumes a failed range on its own", func() {
+		interrupted := false
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			interrupt := !interrupted && r.Header.Get("Range") == "bytes=16384-32767"
+			interrupted = interrupted || interrupt
+			mutex.Unlock()
+
+			if interrupt {
+				w.Header().Set("Content-Range", "bytes 16384-32767/65536")
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write(content[16384:20000])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			serveContent(w, r)
+		}
+
+		_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{SHA256: func() string {
+			sum := sha256.Sum256(content)
+			return hex.EncodeToString(sum[:])
+		}()})
+		Expect(err).NotTo(HaveOccurred())
+		expectOnlyFile()
+		Expect(sortedRanges()).To(Equal([]string{"bytes=0-16383", "bytes=16384-32767", "bytes=20000-32767", "bytes=32768-49151", "bytes=49152-65535"}))
+	})
+
+	it("fails when a range fails permanently", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") == "bytes=0-16383" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			serveContent(w, r)
+		}
+
+		_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{})
+		var respErr client.ResponseError
+		Expect(errors.As(err, &respErr)).To(BeTrue())
+		Expect(respErr.StatusCode).To(Equal(http.StatusForbidden))
+
+		entries, _ := os.ReadDir(dir)
+		Expect(entries).To(BeEmpty())
+	})
+
+	it("fails when the file does not match its digest", func() {
+		_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{SHA256: hex.EncodeToString(make([]byte, 32))})
+		Expect(errors.Is(err, client.ErrDigestMismatch)).To(BeTrue())
+
+		entries, _ := os.ReadDir(dir)
+		Expect(entries).To(BeEmpty())
+	})
+
+	it("fails without downloading when the HEAD request fails", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{})
+		var respErr client.ResponseError
+		Expect(errors.As(err, &respErr)).To(BeTrue())
+		Expect(respErr.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(methods).To(Equal([]string{http.MethodHead}))
+
+		callout := client.New()
+		Expect(callout.Close()).To(Succeed())
+		_, err = callout.DownloadParallel(server.URL, path, client.DownloadSettings{})
+		Expect(err).To(MatchError(client.ErrClosed))
+		Expect(methods).To(HaveLen(1))
+	})
+
+	when("the server does not support ranges", func() {
+		it("downloads the file in a single stream", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				_, _ = w.Write(content)
+			}
+
+			response, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			expectOnlyFile()
+			Expect(methods).To(Equal([]string{http.MethodHead, http.MethodGet}))
+			Expect(ranges).To(Equal([]string{""}))
+		})
+
+		it("downloads the file in a single stream when there is no validator", func() {
+			versions := [][]byte{content, bytes.Repeat([]byte("fedcba9876543210"), 4096)}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				current := versions[0]
+				if r.Method == http.MethodGet {
+					versions[0], versions[1] = versions[1], versions[0]
+				}
+				mutex.Unlock()
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(current))
+			}
+
+			_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{})
+			Expect(err).NotTo(HaveOccurred())
+			expectOnlyFile()
+			Expect(methods).To(Equal([]string{http.MethodHead, http.MethodGet}))
+			Expect(ranges).To(Equal([]string{""}))
+		})
+
+		it("downloads the file in a single stream when HEAD is not allowed", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				serveContent(w, r)
+			}
+
+			_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{})
+			Expect(err).NotTo(HaveOccurred())
+			expectOnlyFile()
+			Expect(methods).To(Equal([]string{http.MethodHead, http.MethodGet}))
+		})
+
+		it("falls back to a single stream when ranges are answered with the whole file", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				_, _ = w.Write(content)
+			}
+
+			_, err := client.New().DownloadParallel(server.URL, path, client.DownloadSettings{MaxResumes: -1})
+			Expect(err).NotTo(HaveOccurred())
+			expectOnlyFile()
+			Expect(sortedRanges()).To(ContainElement(""))
+		})
+	})
+}
@@ -0,0 +1,238 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitDownload(t *testing.T) {
+	spec.Run(t, "Download Test", testDownload, spec.Report(report.Terminal{}))
+}
+
+// interrupt sends the headers for the whole of content but only the first
+// half of it, then drops the connection.
+func interrupt(w http.ResponseWriter, content []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	_, _ = w.Write(content[:len(content)/2])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func testDownload(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server     *httptest.Server
+		mutex      sync.Mutex
+		requests   []*http.Request
+		content    []byte
+		etag       string
+		interrupts int
+		handler    func(w http.ResponseWriter, r *http.Request)
+		dir        string
+		path       string
+	)
+
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requests = nil
+		content = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+		etag = `"v1"`
+		interrupts = 0
+		handler = serveContent
+		dir = t.TempDir()
+		path = filepath.Join(dir, "artifact.bin")
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r)
+			interrupted := interrupts > 0
+			if interrupted {
+				interrupts--
+			}
+			mutex.Unlock()
+
+			if interrupted {
+				w.Header().Set("ETag", etag)
+				interrupt(w, content)
+			}
+			handler(w, r)
+		}))
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.Start()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	expectOnlyFile := func(content []byte) {
+		written, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(content))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	}
+
+	it("writes the body to the file and reports progress", func() {
+		var progress []client.DownloadProgress
+		response, err := client.New().Download(server.URL, path, client.DownloadSettings{
+			OnProgress: func(p client.DownloadProgress) {
+				progress = append(progress, p)
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Body).To(BeEmpty())
+		Expect(response.Attempts).To(Equal(1))
+		expectOnlyFile(content)
+
+		Expect(len(progress)).To(BeNumerically(">=", 2))
+		last := progress[len(progress)-1]
+		Expect(last.Bytes).To(Equal(int64(len(content))))
+		Expect(last.Total).To(Equal(int64(len(content))))
+		Expect(last.Rate).To(BeNumerically(">", 0))
+		Expect(requests[0].Header.Get("Accept-Encoding")).To(Equal("identity"))
+	})
+
+	it("gives the file the mode of the file it replaces, or of a new file", func() {
+		probe := filepath.Join(t.TempDir(), "probe")
+		Expect(os.WriteFile(probe, nil, 0o666)).To(Succeed())
+		newInfo, err := os.Stat(probe)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = client.New().Download(server.URL, path, client.DownloadSettings{})
+		Expect(err).NotTo(HaveOccurred())
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(newInfo.Mode().Perm()))
+
+		Expect(os.Chmod(path, 0o640)).To(Succeed())
+		_, err = client.New().Download(server.URL, path, client.DownloadSettings{})
+		Expect(err).NotTo(HaveOccurred())
+		info, err = os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o640)))
+		expectOnlyFile(content)
+	})
+
+	it("resumes an interrupted download from where it stopped", func() {
+		interrupts = 1
+
+		response, err := client.New().Download(server.URL, path, client.DownloadSettings{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusPartialContent))
+		Expect(response.Attempts).To(Equal(2))
+		expectOnlyFile(content)
+
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[1].Header.Get("Range")).To(Equal("bytes=" + strconv.Itoa(len(content)/2) + "-"))
+		Expect(requests[1].Header.Get("If-Range")).To(Equal(`"v1"`))
+	})
+
+	it("starts again when the file changed before resuming", func() {
+		interrupts = 1
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			content = bytes.Repeat([]byte("changed!"), 1000)
+			etag = `"v2"`
+			serveContent(w, r)
+		}
+
+		response, err := client.New().Download(server.URL, path, client.DownloadSettings{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		expectOnlyFile(content)
+	})
+
+	it("starts again when the server ignores the range", func() {
+		interrupts = 1
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(content)
+		}
+
+		_, err := client.New().Download(server.URL, path, client.DownloadSettings{})
+		Expect(err).NotTo(HaveOccurred())
+		expectOnlyFile(content)
+	})
+
+	it("gives up after the resumes run out", func() {
+		interrupts = 3
+
+		_, err := client.New().Download(server.URL, path, client.DownloadSettings{MaxResumes: 2})
+		Expect(err).To(MatchError(ContainSubstring("failed to copy body")))
+		Expect(requests).To(HaveLen(3))
+
+		_, err = os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		entries, _ := os.ReadDir(dir)
+		Expect(entries).To(BeEmpty())
+	})
+
+	it("does not resume when the server fails permanently", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		_, err := client.New().Download(server.URL, path, client.DownloadSettings{})
+		var respErr client.ResponseError
+		Expect(errors.As(err, &respErr)).To(BeTrue())
+		Expect(respErr.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(requests).To(HaveLen(1))
+	})
+
+	when("verifying the digest", func() {
+		it("checks the SHA-256 hash across resumes", func() {
+			interrupts = 1
+			sum := sha256.Sum256(content)
+
+			_, err := client.New().Download(server.URL, path, client.DownloadSettings{SHA256: hex.EncodeToString(sum[:])})
+			Expect(err).NotTo(HaveOccurred())
+			expectOnlyFile(content)
+
+			_, err = client.New().Download(server.URL, path+".other", client.DownloadSettings{SHA256: hex.EncodeToString(make([]byte, 32))})
+			Expect(errors.Is(err, client.ErrDigestMismatch)).To(BeTrue())
+			_, err = os.Stat(path + ".other")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		it("checks the Content-Digest header", func() {
+			sum := sha256.Sum256(content)
+			digest := base64.StdEncoding.EncodeToString(sum[:])
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Digest", "sha-512=:AAAA:, sha-256=:"+digest+":")
+				serveContent(w, r)
+			}
+
+			_, err := client.New().Download(server.URL, path, client.DownloadSettings{})
+			Expect(err).NotTo(HaveOccurred())
+
+			digest = base64.StdEncoding.EncodeToString(make([]byte, 32))
+			_, err = client.New().Download(server.URL, path, client.DownloadSettings{})
+			Expect(errors.Is(err, client.ErrDigestMismatch)).To(BeTrue())
+			expectOnlyFile(content)
+		})
+	})
+}
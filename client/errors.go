@@ -1,6 +1,9 @@
 package client
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type ResponseError struct {
 	URL        string
@@ -11,3 +14,44 @@ type ResponseError struct {
 func (r ResponseError) Error() string {
 	return fmt.Sprintf("error calling %s, got status code %d with body:\n%s", r.URL, r.StatusCode, string(r.Body))
 }
+
+// maxDecodeErrorBody is how much of the response body a DecodeError keeps.
+const maxDecodeErrorBody = 512
+
+// DecodeError is returned when a response body cannot be decoded. Body holds
+// the start of the offending response body.
+type DecodeError struct {
+	URL  string
+	Body []byte
+	Err  error
+}
+
+func newDecodeError(url string, body []byte, err error) DecodeError {
+	if len(body) > maxDecodeErrorBody {
+		body = body[:maxDecodeErrorBody]
+	}
+	return DecodeError{
+		URL:  url,
+		Body: body,
+		Err:  err,
+	}
+}
+
+func (d DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode response from %s: %s, with body:\n%s", d.URL, d.Err, string(d.Body))
+}
+
+func (d DecodeError) Unwrap() error {
+	return d.Err
+}
+
+// ErrCircuitOpen is returned without making a request while the circuit
+// breaker for the host is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrRateLimited is returned without making a request when a rate limit set to
+// fail fast has no requests left.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrClosed is returned without making a request once the Callout is closed.
+var ErrClosed = errors.New("callout is closed")
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// FormBody sends values as an application/x-www-form-urlencoded request body,
+// setting the Content-Type header unless it is set on the request.
+func FormBody(values url.Values) RequestOption {
+	return func(r *requestOptions) {
+		r.body = func(int64) (*requestBody, error) {
+			body := bytesBody([]byte(values.Encode()))
+			if body != nil {
+				body.headers = map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+			}
+			return body, nil
+		}
+	}
+}
+
+// WithMultipartBody sends body as a multipart/form-data request body, setting
+// the Content-Type header unless it is set on the request.
+func WithMultipartBody(body *MultipartBody) RequestOption {
+	return func(r *requestOptions) {
+		r.body = body.requestBody
+	}
+}
+
+// MultipartBody builds a multipart/form-data request body, which is streamed
+// as it is sent rather than built in memory. Its parts are read again for
+// every retry: files on disk are reopened, readers that implement io.ReaderAt
+// and io.Seeker are re-read and other readers are buffered up to the limit set
+// with WithMaxBufferedBody. When a reader is too large to buffer the body is
+// sent once and not retried.
+type MultipartBody struct {
+	boundary string
+	parts    []multipartPart
+}
+
+type multipartPart struct {
+	header textproto.MIMEHeader
+	body   bodySource
+}
+
+// NewMultipartBody returns an empty MultipartBody with a random boundary.
+func NewMultipartBody() *MultipartBody {
+	return &MultipartBody{boundary: multipart.NewWriter(nil).Boundary()}
+}
+
+// Field adds a form field.
+func (m *MultipartBody) Field(name, value string) *MultipartBody {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(name)))
+	return m.Part(header, strings.NewReader(value))
+}
+
+// File adds a file read from r, with a Content-Type based on the extension of
+// filename.
+func (m *MultipartBody) File(field, filename string, r io.Reader) *MultipartBody {
+	m.parts = append(m.parts, multipartPart{
+		header: fileHeader(field, filename),
+		body: func(maxBuffered int64) (*requestBody, error) {
+			return readerBody(r, maxBuffered)
+		},
+	})
+	return m
+}
+
+// FileFromDisk adds the file at path, which is opened when the body is sent.
+func (m *MultipartBody) FileFromDisk(field, path string) *MultipartBody {
+	m.parts = append(m.parts, multipartPart{
+		header: fileHeader(field, filepath.Base(path)),
+		body: func(int64) (*requestBody, error) {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read multipart file: %w", err)
+			}
+			return &requestBody{
+				open: func() (io.ReadCloser, error) {
+					return os.Open(path)
+				},
+				length:     info.Size(),
+				replayable: true,
+			}, nil
+		},
+	})
+	return m
+}
+
+// Part adds a part with custom headers, such as a Content-Disposition other
+// than form-data or a Content-Transfer-Encoding, read from r.
+func (m *MultipartBody) Part(header textproto.MIMEHeader, r io.Reader) *MultipartBody {
+	m.parts = append(m.parts, multipartPart{
+		header: header,
+		body: func(maxBuffered int64) (*requestBody, error) {
+			return readerBody(r, maxBuffered)
+		},
+	})
+	return m
+}
+
+func fileHeader(field, filename string) textproto.MIMEHeader {
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", contentType)
+	return header
+}
+
+// requestBody streams the parts through a pipe on every attempt. The length is
+// known when the length of every part is, as the multipart framing around them
+// does not depend on their contents.
+func (m *MultipartBody) requestBody(maxBuffered int64) (*requestBody, error) {
+	framing := &countingWriter{}
+	writer := multipart.NewWriter(framing)
+	if err := writer.SetBoundary(m.boundary); err != nil {
+		return nil, err
+	}
+
+	bodies := make([]*requestBody, len(m.parts))
+	length := int64(0)
+	replayable := true
+	for i, part := range m.parts {
+		body, err := part.body(maxBuffered)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = writer.CreatePart(part.header); err != nil {
+			return nil, err
+		}
+		if body == nil {
+			continue
+		}
+
+		bodies[i] = body
+		replayable = replayable && body.replayable
+		if body.length < 0 || length < 0 {
+			length = -1
+		} else {
+			length += body.length
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if length >= 0 {
+		length += framing.n
+	}
+
+	return &requestBody{
+		open: func() (io.ReadCloser, error) {
+			reader, pipeWriter := io.Pipe()
+			go func() {
+				_ = pipeWriter.CloseWithError(m.write(pipeWriter, bodies))
+			}()
+			return reader, nil
+		},
+		length:     length,
+		replayable: replayable,
+		headers:    map[string]string{"Content-Type": writer.FormDataContentType()},
+	}, nil
+}
+
+func (m *MultipartBody) write(w io.Writer, bodies []*requestBody) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+
+	for i, part := range m.parts {
+		partWriter, err := writer.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
+		if bodies[i] == nil {
+			continue
+		}
+
+		reader, err := bodies[i].open()
+		if err != nil {
+			return fmt.Errorf("failed to open multipart body: %w", err)
+		}
+		_, err = io.Copy(partWriter, reader)
+		_ = reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
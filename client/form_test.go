@@ -0,0 +1,158 @@
+package client_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnitForm(t *testing.T) {
+	spec.Run(t, "Form Test", testForm, spec.Report(report.Terminal{}))
+}
+
+type receivedPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+func testForm(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server        *httptest.Server
+		requests      []*http.Request
+		contentLength []int64
+		parts         [][]receivedPart
+		form          url.Values
+		fail          int
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requests, contentLength, parts, form, fail = nil, nil, nil, nil, 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			contentLength = append(contentLength, r.ContentLength)
+
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+				reader, err := r.MultipartReader()
+				Expect(err).NotTo(HaveOccurred())
+				var received []receivedPart
+				for {
+					part, err := reader.NextPart()
+					if err == io.EOF {
+						break
+					}
+					Expect(err).NotTo(HaveOccurred())
+					body, err := io.ReadAll(part)
+					Expect(err).NotTo(HaveOccurred())
+					received = append(received, receivedPart{header: part.Header, body: string(body)})
+				}
+				parts = append(parts, received)
+			} else {
+				Expect(r.ParseForm()).To(Succeed())
+				form = r.PostForm
+			}
+
+			if fail > 0 {
+				fail--
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("FormBody", func() {
+		it("sends url encoded values", func() {
+			values := url.Values{"name": {"widget"}, "tags": {"a", "b&c"}}
+
+			_, err := client.New().Post(server.URL, "", client.FormBody(values))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
+			Expect(form).To(Equal(values))
+		})
+	})
+
+	when("WithMultipartBody", func() {
+		var path string
+
+		it.Before(func() {
+			path = filepath.Join(t.TempDir(), "report.csv")
+			Expect(os.WriteFile(path, []byte("a,b\n1,2\n"), 0o600)).To(Succeed())
+		})
+
+		it("sends fields, files and custom parts with a Content-Length", func() {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="metadata"`)
+			header.Set("Content-Type", "application/json")
+			body := client.NewMultipartBody().
+				Field("title", `Q3 "final"`).
+				File("image", "logo.png", strings.NewReader("png data")).
+				FileFromDisk("report", path).
+				Part(header, strings.NewReader(`{"version":2}`))
+
+			_, err := client.New().Post(server.URL, "", client.WithMultipartBody(body))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests[0].Header.Get("Content-Type")).To(HavePrefix("multipart/form-data; boundary="))
+			Expect(contentLength[0]).To(BeNumerically(">", 0))
+
+			received := parts[0]
+			Expect(received).To(HaveLen(4))
+			Expect(received[0].header.Get("Content-Disposition")).To(Equal(`form-data; name="title"`))
+			Expect(received[0].body).To(Equal(`Q3 "final"`))
+			Expect(received[1].header.Get("Content-Disposition")).To(Equal(`form-data; name="image"; filename="logo.png"`))
+			Expect(received[1].header.Get("Content-Type")).To(Equal("image/png"))
+			Expect(received[1].body).To(Equal("png data"))
+			Expect(received[2].header.Get("Content-Disposition")).To(Equal(`form-data; name="report"; filename="report.csv"`))
+			Expect(received[2].body).To(Equal("a,b\n1,2\n"))
+			Expect(received[3].header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(received[3].body).To(Equal(`{"version":2}`))
+		})
+
+		it("sends the same body again when retrying", func() {
+			fail = 1
+			body := client.NewMultipartBody().
+				Field("title", "retried").
+				File("data", "data.bin", io.MultiReader(strings.NewReader("buffered"))).
+				FileFromDisk("report", path)
+
+			response, err := client.New().Send(http.MethodPut, server.URL, "", client.WithMultipartBody(body), client.WithRetries(1))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Attempts).To(Equal(2))
+			Expect(parts).To(HaveLen(2))
+			Expect(parts[1]).To(Equal(parts[0]))
+			Expect(parts[1][1].body).To(Equal("buffered"))
+		})
+
+		it("streams readers too large to buffer once without a Content-Length", func() {
+			fail = 1
+			large := strings.Repeat("x", 1000)
+			body := client.NewMultipartBody().File("data", "data.bin", io.MultiReader(strings.NewReader(large)))
+
+			_, err := client.New().Put(server.URL, "", client.WithMultipartBody(body), client.WithRetries(1), client.WithMaxBufferedBody(100))
+			Expect(err).To(HaveOccurred())
+			Expect(contentLength).To(Equal([]int64{-1}))
+			Expect(parts[0][0].body).To(Equal(large))
+		})
+
+		it("fails when a file cannot be read", func() {
+			body := client.NewMultipartBody().FileFromDisk("report", filepath.Join(t.TempDir(), "missing.csv"))
+
+			_, err := client.New().Post(server.URL, "", client.WithMultipartBody(body))
+			Expect(err).To(MatchError(ContainSubstring("failed to read multipart file")))
+			Expect(requests).To(BeEmpty())
+		})
+	})
+}
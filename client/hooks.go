@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Hooks observe the attempts made for a request. Any of the hooks may be nil.
+// Hooks added to the Callout run before the hooks added to the request.
+type Hooks struct {
+	// OnRequest is called with the request for each attempt before it is
+	// sent, and may change it. Returning an error fails the request without
+	// sending the attempt.
+	OnRequest func(req *http.Request) error
+	// OnResponse is called with the response for each attempt once it is
+	// received.
+	OnResponse func(resp *http.Response)
+	// OnRetry is called before each retry, which is vetoed when it returns
+	// false.
+	OnRetry func(retry Retry) bool
+	// OnError is called with the error a request fails with. The request is
+	// nil when it could not be built.
+	OnError func(req *http.Request, err error)
+}
+
+// Retry describes a retry about to be made. Err is set when the previous
+// attempt failed to get a response, and StatusCode otherwise.
+type Retry struct {
+	Request    *http.Request
+	Attempt    int
+	StatusCode int
+	Err        error
+	Reason     string
+	Delay      time.Duration
+}
+
+func newRetry(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration) Retry {
+	retry := Retry{
+		Request: req,
+		Attempt: attempt,
+		Err:     err,
+		Delay:   delay,
+	}
+	if err != nil {
+		retry.Reason = err.Error()
+	} else {
+		retry.StatusCode = resp.StatusCode
+		retry.Reason = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return retry
+}
+
+func onRequest(hooks []Hooks, req *http.Request) error {
+	for _, h := range hooks {
+		if h.OnRequest == nil {
+			continue
+		}
+		if err := h.OnRequest(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func onResponse(hooks []Hooks, resp *http.Response) {
+	for _, h := range hooks {
+		if h.OnResponse != nil {
+			h.OnResponse(resp)
+		}
+	}
+}
+
+// onRetry calls every OnRetry hook, returning false when any of them vetoes
+// the retry.
+func onRetry(hooks []Hooks, retry Retry) bool {
+	allowed := true
+	for _, h := range hooks {
+		if h.OnRetry != nil && !h.OnRetry(retry) {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+func onError(hooks []Hooks, req *http.Request, err error) {
+	for _, h := range hooks {
+		if h.OnError != nil {
+			h.OnError(req, err)
+		}
+	}
+}
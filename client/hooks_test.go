@@ -0,0 +1,196 @@
+package client_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUnitHooks(t *testing.T) {
+	spec.Run(t, "Hooks Test", testHooks, spec.Report(report.Terminal{}))
+}
+
+func testHooks(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server       *httptest.Server
+		requestCount int
+		tokens       []string
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requestCount = 0
+		tokens = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCount++
+			tokens = append(tokens, r.Header.Get("Authorization"))
+			if r.URL.Path == "/503" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("OnRequest", func() {
+		it("lets the hook change the request for every attempt", func() {
+			count := 0
+			callout := client.New(client.WithDefaultRetries(1), client.WithDefaultHooks(client.Hooks{
+				OnRequest: func(req *http.Request) error {
+					count++
+					req.Header.Set("Authorization", "Bearer token-"+strconv.Itoa(count))
+					return nil
+				},
+			}))
+
+			_, err := callout.Get(server.URL + "/503")
+			Expect(err).To(HaveOccurred())
+			Expect(tokens).To(Equal([]string{"Bearer token-1", "Bearer token-2"}))
+		})
+
+		it("fails the request without sending it when the hook returns an error", func() {
+			hookErr := errors.New("failed to refresh token")
+			callout := client.New(client.WithDefaultRetries(2))
+
+			_, err := callout.Get(server.URL, client.WithHooks(client.Hooks{
+				OnRequest: func(*http.Request) error {
+					return hookErr
+				},
+			}))
+			Expect(err).To(MatchError(hookErr))
+			Expect(requestCount).To(Equal(0))
+		})
+	})
+
+	when("OnResponse", func() {
+		it("is called with the response for every attempt", func() {
+			var statusCodes []int
+			callout := client.New(client.WithDefaultRetries(2))
+
+			_, err := callout.Get(server.URL+"/503", client.WithHooks(client.Hooks{
+				OnResponse: func(resp *http.Response) {
+					statusCodes = append(statusCodes, resp.StatusCode)
+				},
+			}))
+			Expect(err).To(HaveOccurred())
+			Expect(statusCodes).To(Equal([]int{503, 503, 503}))
+		})
+	})
+
+	when("OnRetry", func() {
+		it("is called with the reason and delay for every retry", func() {
+			var retries []client.Retry
+			clock := newFakeClock()
+			callout := client.New(client.WithClock(clock), client.WithDefaultRetries(2),
+				client.WithDefaultBackoff(client.ConstantBackoff(time.Second)))
+
+			_, err := callout.Get(server.URL+"/503", client.WithHooks(client.Hooks{
+				OnRetry: func(retry client.Retry) bool {
+					retries = append(retries, retry)
+					return true
+				},
+			}))
+			Expect(err).To(HaveOccurred())
+			Expect(retries).To(HaveLen(2))
+			Expect(retries[0].Attempt).To(Equal(1))
+			Expect(retries[1].Attempt).To(Equal(2))
+			Expect(retries[0].StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(retries[0].Reason).To(Equal("503 Service Unavailable"))
+			Expect(retries[0].Delay).To(Equal(time.Second))
+			Expect(retries[0].Request.URL.Path).To(Equal("/503"))
+		})
+
+		it("does not retry when a hook vetoes the retry", func() {
+			callout := client.New(client.WithDefaultRetries(2), client.WithDefaultHooks(client.Hooks{
+				OnRetry: func(client.Retry) bool {
+					return true
+				},
+			}))
+
+			resp, err := callout.Send(http.MethodGet, server.URL+"/503", "", client.WithHooks(client.Hooks{
+				OnRetry: func(client.Retry) bool {
+					return false
+				},
+			}))
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+			Expect(resp.Attempts).To(Equal(1))
+			Expect(requestCount).To(Equal(1))
+		})
+	})
+
+	when("OnError", func() {
+		it("is called with the error the request fails with", func() {
+			var hookErr error
+			var hookReq *http.Request
+			callout := client.New()
+
+			_, err := callout.Get(server.URL+"/503", client.WithHooks(client.Hooks{
+				OnError: func(req *http.Request, err error) {
+					hookReq = req
+					hookErr = err
+				},
+			}))
+			Expect(err).To(HaveOccurred())
+			Expect(hookErr).To(Equal(err))
+			Expect(hookReq.URL.Path).To(Equal("/503"))
+		})
+
+		it("is called without a request when the request cannot be built", func() {
+			var hookErr error
+			hookReq := &http.Request{}
+			callout := client.New()
+
+			_, err := callout.Get("://bad-url", client.WithHooks(client.Hooks{
+				OnError: func(req *http.Request, err error) {
+					hookReq = req
+					hookErr = err
+				},
+			}))
+			Expect(err).To(HaveOccurred())
+			Expect(hookErr).To(Equal(err))
+			Expect(hookReq).To(BeNil())
+		})
+
+		it("is not called when the request succeeds", func() {
+			called := false
+			callout := client.New(client.WithDefaultHooks(client.Hooks{
+				OnError: func(*http.Request, error) {
+					called = true
+				},
+			}))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(called).To(BeFalse())
+		})
+	})
+
+	it("runs the Callout hooks before the request hooks", func() {
+		var calls []string
+		callout := client.New(client.WithDefaultHooks(client.Hooks{
+			OnResponse: func(*http.Response) {
+				calls = append(calls, "callout")
+			},
+		}))
+
+		_, err := callout.Get(server.URL, client.WithHooks(client.Hooks{
+			OnResponse: func(*http.Response) {
+				calls = append(calls, "request")
+			},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"callout", "request"}))
+	})
+}
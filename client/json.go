@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetJSON makes a GET request and decodes the JSON response into a T. Decoding
+// failures are returned as a DecodeError.
+func GetJSON[T any](caller Caller, url string, options ...RequestOption) (T, error) {
+	options = append([]RequestOption{WithHeader("Accept", "application/json")}, options...)
+
+	body, err := caller.Get(url, options...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decodeJSON[T](url, body)
+}
+
+// DoJSON sends req encoded as JSON with the given method and decodes the JSON
+// response into a Resp. Decoding failures are returned as a DecodeError.
+func DoJSON[Req, Resp any](caller Caller, method, url string, req Req, options ...RequestOption) (Resp, error) {
+	options = append([]RequestOption{JSONBody(req)}, options...)
+
+	body, err := caller.Do(method, url, "", options...)
+	if err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return decodeJSON[Resp](url, body)
+}
+
+// PostJSON is DoJSON with a POST request.
+func PostJSON[Req, Resp any](caller Caller, url string, req Req, options ...RequestOption) (Resp, error) {
+	return DoJSON[Req, Resp](caller, http.MethodPost, url, req, options...)
+}
+
+// PutJSON is DoJSON with a PUT request.
+func PutJSON[Req, Resp any](caller Caller, url string, req Req, options ...RequestOption) (Resp, error) {
+	return DoJSON[Req, Resp](caller, http.MethodPut, url, req, options...)
+}
+
+// decodeJSON decodes body into a T, treating an empty body, such as from a 204
+// response, as the zero value.
+func decodeJSON[T any](url string, body []byte) (T, error) {
+	var value T
+	if len(body) == 0 {
+		return value, nil
+	}
+	if err := json.Unmarshal(body, &value); err != nil {
+		var zero T
+		return zero, newDecodeError(url, body, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,142 @@
+package client_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnitJSON(t *testing.T) {
+	spec.Run(t, "JSON Test", testJSON, spec.Report(report.Terminal{}))
+}
+
+type widget struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func testJSON(t *testing.T, when spec.G, it spec.S) {
+	var server *httptest.Server
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/widget":
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"name":"gear","count":3,"accept":%q}`, r.Header.Get("Accept"))
+			case "/echo":
+				var w2 widget
+				if err := json.NewDecoder(r.Body).Decode(&w2); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w2.Count++
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"widget": w2,
+					"method": r.Method,
+				})
+			case "/empty":
+				w.WriteHeader(http.StatusNoContent)
+			case "/html":
+				_, _ = io.WriteString(w, "<html>"+strings.Repeat("x", 1000)+"</html>")
+			case "/500":
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("GetJSON", func() {
+		it("returns the decoded response", func() {
+			callout := client.New()
+
+			result, err := client.GetJSON[widget](callout, server.URL+"/widget")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(widget{Name: "gear", Count: 3}))
+		})
+
+		it("asks for a JSON response", func() {
+			callout := client.New()
+
+			result, err := client.GetJSON[map[string]interface{}](callout, server.URL+"/widget")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result["accept"]).To(Equal("application/json"))
+		})
+
+		it("returns the zero value for an empty response", func() {
+			callout := client.New()
+
+			result, err := client.GetJSON[*widget](callout, server.URL+"/empty")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(BeNil())
+		})
+
+		it("returns a DecodeError with the start of the body when the response is not JSON", func() {
+			callout := client.New()
+
+			result, err := client.GetJSON[widget](callout, server.URL+"/html")
+
+			var decodeErr client.DecodeError
+			Expect(errors.As(err, &decodeErr)).To(BeTrue())
+			Expect(decodeErr.URL).To(Equal(server.URL + "/html"))
+			Expect(string(decodeErr.Body)).To(HavePrefix("<html>xxx"))
+			Expect(decodeErr.Body).To(HaveLen(512))
+			var syntaxErr *json.SyntaxError
+			Expect(errors.As(err, &syntaxErr)).To(BeTrue())
+			Expect(result).To(BeZero())
+		})
+
+		it("returns a ResponseError when the response is not 200", func() {
+			callout := client.New()
+
+			_, err := client.GetJSON[widget](callout, server.URL+"/500")
+
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+		})
+	})
+
+	when("DoJSON", func() {
+		type echo struct {
+			Widget widget `json:"widget"`
+			Method string `json:"method"`
+		}
+
+		it("sends the request as JSON and returns the decoded response", func() {
+			callout := client.New()
+
+			result, err := client.DoJSON[widget, echo](callout, http.MethodPatch, server.URL+"/echo", widget{Name: "gear", Count: 1})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(echo{Widget: widget{Name: "gear", Count: 2}, Method: http.MethodPatch}))
+		})
+
+		it("has helpers for POST and PUT", func() {
+			callout := client.New()
+
+			result, err := client.PostJSON[widget, echo](callout, server.URL+"/echo", widget{Name: "gear"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Method).To(Equal(http.MethodPost))
+
+			result, err = client.PutJSON[widget, echo](callout, server.URL+"/echo", widget{Name: "gear"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Method).To(Equal(http.MethodPut))
+		})
+	})
+}
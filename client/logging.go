@@ -0,0 +1,274 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// defaultRedactedHeaders are always redacted from logged headers.
+var defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// LogSettings configures the logger added with WithLogger.
+type LogSettings struct {
+	// RedactHeaders lists headers whose values are redacted, in addition to
+	// Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+	RedactHeaders []string
+	// RedactQuery lists query parameters whose values are redacted from the
+	// logged URL.
+	RedactQuery []string
+	// RedactJSONFields lists JSON object fields whose values are redacted from
+	// captured bodies, at any depth.
+	RedactJSONFields []string
+	// MaxBodyBytes is how much of the request and response bodies are logged
+	// at debug level. Bodies are not captured when it is zero.
+	MaxBodyBytes int
+}
+
+// callLogger logs each attempt made by a Callout.
+type callLogger struct {
+	logger      *slog.Logger
+	settings    LogSettings
+	headers     map[string]bool
+	query       map[string]bool
+	jsonFields  map[string]bool
+	fieldValues *regexp.Regexp
+}
+
+func newCallLogger(logger *slog.Logger, settings LogSettings) *callLogger {
+	l := &callLogger{
+		logger:     logger,
+		settings:   settings,
+		headers:    map[string]bool{},
+		query:      map[string]bool{},
+		jsonFields: map[string]bool{},
+	}
+	for _, name := range append(defaultRedactedHeaders, settings.RedactHeaders...) {
+		l.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range settings.RedactQuery {
+		l.query[name] = true
+	}
+
+	var fields []string
+	for _, name := range settings.RedactJSONFields {
+		l.jsonFields[strings.ToLower(name)] = true
+		fields = append(fields, regexp.QuoteMeta(name))
+	}
+	if len(fields) > 0 {
+		// Matches the scalar values of the fields, for bodies that were
+		// truncated and can no longer be parsed.
+		l.fieldValues = regexp.MustCompile(`(?i)("(?:` + strings.Join(fields, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	}
+	return l
+}
+
+// logMiddleware logs the method, URL, status, latency and sizes of each
+// attempt once its response body is closed. Headers, and bodies when
+// MaxBodyBytes is set, are included at debug level.
+func (c *Callout) logMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.logger == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		l := c.logger
+		ctx := req.Context()
+		debug := l.logger.Enabled(ctx, slog.LevelDebug)
+		attempt := callFrom(ctx).attempts
+		start := c.clock.Now()
+
+		var reqBody *capturedBody
+		if req.Body != nil && req.Body != http.NoBody {
+			reqBody = newCapturedBody(req.Body, l.captureLimit(debug))
+			req = req.Clone(ctx)
+			req.Body = reqBody
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("url", l.redactURL(req.URL)),
+			slog.Int("attempt", attempt),
+		}
+		if debug {
+			attrs = append(attrs, slog.Any("request_headers", l.redactHeaders(req.Header)))
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			attrs = append(attrs,
+				slog.Duration("latency", c.clock.Now().Sub(start)),
+				slog.Int64("request_bytes", reqBody.size()),
+				slog.String("error", err.Error()),
+			)
+			attrs = append(attrs, l.bodyAttrs("request_body", reqBody, req.Header)...)
+			l.logger.LogAttrs(ctx, slog.LevelError, "outbound request failed", attrs...)
+			return nil, err
+		}
+
+		respBody := newCapturedBody(resp.Body, l.captureLimit(debug))
+		respBody.onClose = func() {
+			attrs := append(attrs,
+				slog.Int("status", resp.StatusCode),
+				slog.Duration("latency", c.clock.Now().Sub(start)),
+				slog.Int64("request_bytes", reqBody.size()),
+				slog.Int64("response_bytes", respBody.size()),
+			)
+			if debug {
+				attrs = append(attrs, slog.Any("response_headers", l.redactHeaders(resp.Header)))
+			}
+			attrs = append(attrs, l.bodyAttrs("request_body", reqBody, req.Header)...)
+			attrs = append(attrs, l.bodyAttrs("response_body", respBody, resp.Header)...)
+
+			level := slog.LevelInfo
+			if resp.StatusCode >= 400 {
+				level = slog.LevelWarn
+			}
+			l.logger.LogAttrs(context.Background(), level, "outbound request", attrs...)
+		}
+		resp.Body = respBody
+		return resp, nil
+	})
+}
+
+func (l *callLogger) captureLimit(debug bool) int {
+	if !debug {
+		return 0
+	}
+	return l.settings.MaxBodyBytes
+}
+
+func (l *callLogger) redactURL(u *url.URL) string {
+	redactedURL := *u
+	if len(l.query) > 0 && u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if l.query[name] {
+				query[name] = []string{redacted}
+			}
+		}
+		redactedURL.RawQuery = query.Encode()
+	}
+	return redactedURL.Redacted()
+}
+
+func (l *callLogger) redactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if l.headers[http.CanonicalHeaderKey(name)] {
+			headers[name] = redacted
+		} else {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+func (l *callLogger) bodyAttrs(key string, body *capturedBody, header http.Header) []slog.Attr {
+	captured := body.captured()
+	if len(captured) == 0 {
+		return nil
+	}
+	if strings.Contains(header.Get("Content-Type"), "json") {
+		captured = l.redactJSON(captured)
+	}
+	return []slog.Attr{slog.String(key, string(captured))}
+}
+
+// redactJSON redacts the configured fields from a JSON body, falling back to
+// redacting scalar values in place when the body was truncated.
+func (l *callLogger) redactJSON(body []byte) []byte {
+	if len(l.jsonFields) == 0 {
+		return body
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err == nil {
+		if redactedBody, err := json.Marshal(l.redactValue(value)); err == nil {
+			return redactedBody
+		}
+	}
+	return l.fieldValues.ReplaceAll(body, []byte(`${1}"`+redacted+`"`))
+}
+
+func (l *callLogger) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if l.jsonFields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = l.redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = l.redactValue(item)
+		}
+	}
+	return value
+}
+
+// capturedBody counts the bytes read from a body, keeping up to limit of them,
+// and calls onClose once it is closed. Request bodies are read by the
+// transport while the response is handled, so it is safe for concurrent use.
+type capturedBody struct {
+	io.ReadCloser
+	limit   int
+	mutex   sync.Mutex
+	read    int64
+	buffer  bytes.Buffer
+	once    sync.Once
+	onClose func()
+}
+
+func newCapturedBody(body io.ReadCloser, limit int) *capturedBody {
+	return &capturedBody{ReadCloser: body, limit: limit}
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.read += int64(n)
+	if remaining := b.limit - b.buffer.Len(); remaining > 0 {
+		b.buffer.Write(p[:min(n, remaining)])
+	}
+	return n, err
+}
+
+func (b *capturedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.onClose != nil {
+		b.once.Do(b.onClose)
+	}
+	return err
+}
+
+func (b *capturedBody) size() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.read
+}
+
+func (b *capturedBody) captured() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte(nil), b.buffer.Bytes()...)
+}
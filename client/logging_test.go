@@ -0,0 +1,154 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnitLogging(t *testing.T) {
+	spec.Run(t, "Logging Test", testLogging, spec.Report(report.Terminal{}))
+}
+
+func testLogging(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server *httptest.Server
+		output *bytes.Buffer
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		output = &bytes.Buffer{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=secret")
+			if r.URL.Path == "/500" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			_, _ = io.Copy(w, r.Body)
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	newLogger := func(level slog.Level) *slog.Logger {
+		return slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level}))
+	}
+
+	records := func() []map[string]interface{} {
+		var records []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			record := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			records = append(records, record)
+		}
+		return records
+	}
+
+	it("logs every attempt", func() {
+		callout := client.New(client.WithLogger(newLogger(slog.LevelInfo), client.LogSettings{}), client.WithDefaultRetries(1))
+
+		_, err := callout.Put(server.URL+"/500", "body")
+		Expect(err).To(HaveOccurred())
+
+		logged := records()
+		Expect(logged).To(HaveLen(2))
+		Expect(logged[0]).To(HaveKeyWithValue("msg", "outbound request"))
+		Expect(logged[0]).To(HaveKeyWithValue("level", "WARN"))
+		Expect(logged[0]).To(HaveKeyWithValue("method", "PUT"))
+		Expect(logged[0]).To(HaveKeyWithValue("url", server.URL+"/500"))
+		Expect(logged[0]).To(HaveKeyWithValue("status", BeNumerically("==", 500)))
+		Expect(logged[0]).To(HaveKeyWithValue("attempt", BeNumerically("==", 1)))
+		Expect(logged[0]).To(HaveKeyWithValue("request_bytes", BeNumerically("==", 4)))
+		Expect(logged[0]).To(HaveKeyWithValue("response_bytes", BeNumerically("==", 4)))
+		Expect(logged[0]).To(HaveKey("latency"))
+		Expect(logged[1]).To(HaveKeyWithValue("attempt", BeNumerically("==", 2)))
+		Expect(logged[0]).NotTo(HaveKey("request_headers"))
+		Expect(logged[0]).NotTo(HaveKey("request_body"))
+	})
+
+	it("logs requests that fail at error level", func() {
+		callout := client.New(client.WithLogger(newLogger(slog.LevelInfo), client.LogSettings{}))
+
+		_, err := callout.Get("http://127.0.0.1:1")
+		Expect(err).To(HaveOccurred())
+
+		logged := records()
+		Expect(logged).To(HaveLen(1))
+		Expect(logged[0]).To(HaveKeyWithValue("msg", "outbound request failed"))
+		Expect(logged[0]).To(HaveKeyWithValue("level", "ERROR"))
+		Expect(logged[0]).To(HaveKeyWithValue("error", ContainSubstring("connection refused")))
+	})
+
+	it("redacts sensitive headers and query parameters", func() {
+		callout := client.New(client.WithLogger(newLogger(slog.LevelDebug), client.LogSettings{
+			RedactHeaders: []string{"x-api-key"},
+			RedactQuery:   []string{"token"},
+		}))
+
+		_, err := callout.Get(server.URL+"/?token=secret&page=2",
+			client.WithHeader("Authorization", "Bearer secret"),
+			client.WithHeader("X-Api-Key", "secret"),
+			client.WithHeader("X-Request-Id", "id"),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		logged := records()
+		Expect(logged).To(HaveLen(1))
+		Expect(logged[0]).To(HaveKeyWithValue("url", server.URL+"/?page=2&token=REDACTED"))
+		Expect(logged[0]["request_headers"]).To(HaveKeyWithValue("Authorization", "REDACTED"))
+		Expect(logged[0]["request_headers"]).To(HaveKeyWithValue("X-Api-Key", "REDACTED"))
+		Expect(logged[0]["request_headers"]).To(HaveKeyWithValue("X-Request-Id", "id"))
+		Expect(logged[0]["response_headers"]).To(HaveKeyWithValue("Set-Cookie", "REDACTED"))
+		Expect(output.String()).NotTo(ContainSubstring("secret"))
+	})
+
+	it("captures bodies at debug level with the JSON fields redacted", func() {
+		callout := client.New(client.WithLogger(newLogger(slog.LevelDebug), client.LogSettings{
+			RedactJSONFields: []string{"password"},
+			MaxBodyBytes:     1024,
+		}))
+
+		_, err := callout.Post(server.URL, "", client.JSONBody(map[string]interface{}{
+			"user":   "alice",
+			"nested": map[string]string{"password": "secret"},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+
+		logged := records()
+		Expect(logged).To(HaveLen(1))
+		Expect(logged[0]).To(HaveKeyWithValue("request_body", MatchJSON(`{"user":"alice","nested":{"password":"REDACTED"}}`)))
+		Expect(logged[0]).To(HaveKeyWithValue("response_body", MatchJSON(`{"user":"alice","nested":{"password":"REDACTED"}}`)))
+	})
+
+	it("caps captured bodies, redacting fields in truncated JSON", func() {
+		callout := client.New(client.WithLogger(newLogger(slog.LevelDebug), client.LogSettings{
+			RedactJSONFields: []string{"password"},
+			MaxBodyBytes:     30,
+		}))
+
+		_, err := callout.Post(server.URL, `{"password":"secret","padding":"0123456789"}`,
+			client.WithHeader("Content-Type", "application/json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		logged := records()
+		Expect(logged).To(HaveLen(1))
+		Expect(logged[0]).To(HaveKeyWithValue("request_body", `{"password":"REDACTED","padding"`))
+		Expect(logged[0]).To(HaveKeyWithValue("request_bytes", BeNumerically("==", 44)))
+	})
+}
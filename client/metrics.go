@@ -0,0 +1,285 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics records what a Callout does, as added with WithMetrics. Requests
+// are recorded for every attempt sent, so retries are counted as requests
+// too.
+type Metrics interface {
+	RequestStarted(labels MetricLabels)
+	// RequestFinished is called once the response body is closed, or the
+	// attempt fails to get a response.
+	RequestFinished(labels MetricLabels, duration time.Duration)
+	RequestRetried(labels MetricLabels)
+	CircuitStateChanged(host string, from, to CircuitState)
+	CircuitRejected(host string)
+	RateLimitWaited(host string, wait time.Duration)
+	RateLimitRejected(host string)
+}
+
+// MetricLabels describe a request. StatusClass is empty for requests that
+// have not finished, "error" for requests that failed to get a response and
+// otherwise the class of the status, such as "2xx".
+type MetricLabels struct {
+	Host        string
+	Method      string
+	StatusClass string
+}
+
+func newMetricLabels(req *http.Request, resp *http.Response, err error) MetricLabels {
+	labels := MetricLabels{
+		Host:   req.URL.Host,
+		Method: req.Method,
+	}
+	switch {
+	case err != nil:
+		labels.StatusClass = "error"
+	case resp != nil:
+		labels.StatusClass = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	return labels
+}
+
+// metricsMiddleware records each attempt as it is sent and once it finishes.
+func (c *Callout) metricsMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.metrics == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c.metrics.RequestStarted(newMetricLabels(req, nil, nil))
+		start := c.clock.Now()
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			c.metrics.RequestFinished(newMetricLabels(req, nil, err), c.clock.Now().Sub(start))
+			return nil, err
+		}
+
+		resp.Body = &onCloseBody{
+			ReadCloser: resp.Body,
+			onClose: func(int64) {
+				c.metrics.RequestFinished(newMetricLabels(req, resp, nil), c.clock.Now().Sub(start))
+			},
+		}
+		return resp, nil
+	})
+}
+
+// recordRetry records a retry made after an attempt got resp or err.
+func (c *Callout) recordRetry(req *http.Request, resp *http.Response, err error) {
+	if c.metrics != nil {
+		c.metrics.RequestRetried(newMetricLabels(req, resp, err))
+	}
+}
+
+// recordRejection records an attempt that the circuit breaker or rate limiter
+// did not let through.
+func (c *Callout) recordRejection(host string, err error) {
+	switch {
+	case c.metrics == nil:
+	case errors.Is(err, ErrCircuitOpen):
+		c.metrics.CircuitRejected(host)
+	case errors.Is(err, ErrRateLimited):
+		c.metrics.RateLimitRejected(host)
+	}
+}
+
+// recordStateChange returns a state change callback that records the change
+// before calling onStateChange.
+func (c *Callout) recordStateChange(onStateChange func(host string, from, to CircuitState)) func(host string, from, to CircuitState) {
+	return func(host string, from, to CircuitState) {
+		c.metrics.CircuitStateChanged(host, from, to)
+		if onStateChange != nil {
+			onStateChange(host, from, to)
+		}
+	}
+}
+
+// defaultDurationBuckets are the upper bounds of the request duration
+// histogram kept by MemoryMetrics.
+var defaultDurationBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// MemoryMetrics keeps the metrics recorded by a Callout in memory, where they
+// can be read by tests or written out with WritePrometheus.
+type MemoryMetrics struct {
+	mutex          sync.Mutex
+	buckets        []time.Duration
+	requests       map[MetricLabels]int
+	durations      map[MetricLabels]*histogram
+	inFlight       map[MetricLabels]int
+	retries        map[MetricLabels]int
+	circuitStates  map[string]CircuitState
+	circuitRejects map[string]int
+	limitWaits     map[string]int
+	limitWaited    map[string]time.Duration
+	limitRejects   map[string]int
+}
+
+type histogram struct {
+	counts []int
+	count  int
+	sum    time.Duration
+}
+
+// Ensure MemoryMetrics implements Metrics interface
+var _ Metrics = &MemoryMetrics{}
+
+// NewMemoryMetrics returns an empty MemoryMetrics. The request durations are
+// counted in buckets with the given upper bounds, or in buckets from 5ms to
+// 10s when there are none.
+func NewMemoryMetrics(buckets ...time.Duration) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = defaultDurationBuckets
+	}
+	return &MemoryMetrics{
+		buckets:        buckets,
+		requests:       map[MetricLabels]int{},
+		durations:      map[MetricLabels]*histogram{},
+		inFlight:       map[MetricLabels]int{},
+		retries:        map[MetricLabels]int{},
+		circuitStates:  map[string]CircuitState{},
+		circuitRejects: map[string]int{},
+		limitWaits:     map[string]int{},
+		limitWaited:    map[string]time.Duration{},
+		limitRejects:   map[string]int{},
+	}
+}
+
+func (m *MemoryMetrics) RequestStarted(labels MetricLabels) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.inFlight[MetricLabels{Host: labels.Host, Method: labels.Method}]++
+}
+
+func (m *MemoryMetrics) RequestFinished(labels MetricLabels, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.inFlight[MetricLabels{Host: labels.Host, Method: labels.Method}]--
+	m.requests[labels]++
+
+	h, ok := m.durations[labels]
+	if !ok {
+		h = &histogram{counts: make([]int, len(m.buckets))}
+		m.durations[labels] = h
+	}
+	for i, bound := range m.buckets {
+		if duration <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += duration
+}
+
+func (m *MemoryMetrics) RequestRetried(labels MetricLabels) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.retries[labels]++
+}
+
+func (m *MemoryMetrics) CircuitStateChanged(host string, from, to CircuitState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.circuitStates[host] = to
+}
+
+func (m *MemoryMetrics) CircuitRejected(host string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.circuitRejects[host]++
+}
+
+func (m *MemoryMetrics) RateLimitWaited(host string, wait time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.limitWaits[host]++
+	m.limitWaited[host] += wait
+}
+
+func (m *MemoryMetrics) RateLimitRejected(host string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.limitRejects[host]++
+}
+
+// Requests returns how many requests finished with labels.
+func (m *MemoryMetrics) Requests(labels MetricLabels) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requests[labels]
+}
+
+// Durations returns how many requests finished with labels and how long they
+// took in total.
+func (m *MemoryMetrics) Durations(labels MetricLabels) (int, time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if h, ok := m.durations[labels]; ok {
+		return h.count, h.sum
+	}
+	return 0, 0
+}
+
+// InFlight returns how many requests to host with method are in flight.
+func (m *MemoryMetrics) InFlight(host, method string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.inFlight[MetricLabels{Host: host, Method: method}]
+}
+
+// Retries returns how many retries were made after an attempt with labels.
+func (m *MemoryMetrics) Retries(labels MetricLabels) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.retries[labels]
+}
+
+// CircuitState returns the state of the circuit for host.
+func (m *MemoryMetrics) CircuitState(host string) CircuitState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.circuitStates[host]
+}
+
+// CircuitRejections returns how many attempts to host failed fast while its
+// circuit was open.
+func (m *MemoryMetrics) CircuitRejections(host string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.circuitRejects[host]
+}
+
+// RateLimitWaits returns how many attempts to host waited for the rate limit
+// and how long they waited in total.
+func (m *MemoryMetrics) RateLimitWaits(host string) (int, time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.limitWaits[host], m.limitWaited[host]
+}
+
+// RateLimitRejections returns how many attempts to host failed fast because
+// of the rate limit.
+func (m *MemoryMetrics) RateLimitRejections(host string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.limitRejects[host]
+}
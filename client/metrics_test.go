@@ -0,0 +1,195 @@
+package client_test
+
+import (
+	"bytes"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnitMetrics(t *testing.T) {
+	spec.Run(t, "Metrics Test", testMetrics, spec.Report(report.Terminal{}))
+}
+
+func testMetrics(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server  *httptest.Server
+		host    string
+		metrics *client.MemoryMetrics
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		metrics = client.NewMemoryMetrics()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/503" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		host = strings.TrimPrefix(server.URL, "http://")
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	labels := func(method, statusClass string) client.MetricLabels {
+		return client.MetricLabels{Host: host, Method: method, StatusClass: statusClass}
+	}
+
+	it("records every attempt with its duration", func() {
+		callout := client.New(client.WithMetrics(metrics), client.WithDefaultRetries(2))
+
+		_, err := callout.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = callout.Get(server.URL + "/503")
+		Expect(err).To(HaveOccurred())
+
+		Expect(metrics.Requests(labels(http.MethodGet, "2xx"))).To(Equal(1))
+		Expect(metrics.Requests(labels(http.MethodGet, "5xx"))).To(Equal(3))
+		Expect(metrics.Retries(labels(http.MethodGet, "5xx"))).To(Equal(2))
+		Expect(metrics.InFlight(host, http.MethodGet)).To(Equal(0))
+
+		count, sum := metrics.Durations(labels(http.MethodGet, "5xx"))
+		Expect(count).To(Equal(3))
+		Expect(sum).To(BeNumerically(">", 0))
+	})
+
+	it("records requests that fail to get a response", func() {
+		callout := client.New(client.WithMetrics(metrics))
+
+		_, err := callout.Get("http://127.0.0.1:1")
+		Expect(err).To(HaveOccurred())
+
+		Expect(metrics.Requests(client.MetricLabels{Host: "127.0.0.1:1", Method: http.MethodGet, StatusClass: "error"})).To(Equal(1))
+	})
+
+	it("counts requests in flight until the response is read", func() {
+		var inFlight int
+		callout := client.New(client.WithMetrics(metrics), client.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+			return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				inFlight = metrics.InFlight(host, req.Method)
+				return next.RoundTrip(req)
+			})
+		}))
+
+		_, err := callout.Post(server.URL, "body")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inFlight).To(Equal(1))
+		Expect(metrics.InFlight(host, http.MethodPost)).To(Equal(0))
+	})
+
+	it("records the circuit breaker events", func() {
+		var changes []client.CircuitState
+		callout := client.New(client.WithMetrics(metrics), client.WithCircuitBreaker(client.CircuitBreakerSettings{
+			ConsecutiveFailures: 1,
+			OnStateChange: func(host string, from, to client.CircuitState) {
+				changes = append(changes, to)
+			},
+		}))
+
+		_, err := callout.Get(server.URL + "/503")
+		Expect(err).To(HaveOccurred())
+		_, err = callout.Get(server.URL)
+		Expect(err).To(MatchError(client.ErrCircuitOpen))
+
+		Expect(metrics.CircuitState(host)).To(Equal(client.CircuitOpen))
+		Expect(metrics.CircuitRejections(host)).To(Equal(1))
+		Expect(metrics.Requests(labels(http.MethodGet, "5xx"))).To(Equal(1))
+		Expect(changes).To(Equal([]client.CircuitState{client.CircuitOpen}))
+	})
+
+	it("records the circuit breaker events of each callout sharing a circuit breaker option", func() {
+		breaker := client.WithCircuitBreaker(client.CircuitBreakerSettings{ConsecutiveFailures: 1})
+		other := client.NewMemoryMetrics()
+		_ = client.New(client.WithMetrics(metrics), breaker)
+		callout := client.New(client.WithMetrics(other), breaker)
+
+		_, err := callout.Get(server.URL + "/503")
+		Expect(err).To(HaveOccurred())
+
+		Expect(other.CircuitState(host)).To(Equal(client.CircuitOpen))
+		Expect(metrics.CircuitState(host)).To(Equal(client.CircuitClosed))
+	})
+
+	it("records the rate limit events", func() {
+		callout := client.New(client.WithMetrics(metrics), client.WithClock(newFakeClock()),
+			client.WithRateLimit(client.RateLimit{Rate: 1, Burst: 1}))
+		failFast := client.New(client.WithMetrics(metrics), client.WithClock(newFakeClock()),
+			client.WithRateLimit(client.RateLimit{Rate: 1, Burst: 1, FailFast: true}))
+
+		for i := 0; i < 2; i++ {
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, _ = failFast.Get(server.URL)
+		}
+
+		waits, waited := metrics.RateLimitWaits(host)
+		Expect(waits).To(Equal(1))
+		Expect(waited).To(Equal(time.Second))
+		Expect(metrics.RateLimitRejections(host)).To(Equal(1))
+	})
+
+	when("WritePrometheus", func() {
+		it("writes the metrics in the text exposition format", func() {
+			metrics = client.NewMemoryMetrics(100*time.Millisecond, time.Second)
+			ok := client.MetricLabels{Host: "api.example.com", Method: http.MethodGet, StatusClass: "2xx"}
+			metrics.RequestStarted(ok)
+			metrics.RequestFinished(ok, 50*time.Millisecond)
+			metrics.RequestStarted(ok)
+			metrics.RequestFinished(ok, 500*time.Millisecond)
+			metrics.RequestStarted(client.MetricLabels{Host: "api.example.com", Method: http.MethodPost})
+			metrics.RequestRetried(client.MetricLabels{Host: `quoted"host`, Method: http.MethodGet, StatusClass: "error"})
+			metrics.CircuitStateChanged("api.example.com", client.CircuitClosed, client.CircuitOpen)
+			metrics.CircuitRejected("api.example.com")
+			metrics.RateLimitWaited("api.example.com", 250*time.Millisecond)
+			metrics.RateLimitRejected("api.example.com")
+
+			buffer := &bytes.Buffer{}
+			Expect(metrics.WritePrometheus(buffer)).To(Succeed())
+
+			Expect(buffer.String()).To(Equal(`# HELP libhttp_client_requests_total Requests sent by the client, including retries.
+# TYPE libhttp_client_requests_total counter
+libhttp_client_requests_total{host="api.example.com",method="GET",status_class="2xx"} 2
+# HELP libhttp_client_request_duration_seconds Time taken by requests until their response body was closed.
+# TYPE libhttp_client_request_duration_seconds histogram
+libhttp_client_request_duration_seconds_bucket{host="api.example.com",method="GET",status_class="2xx",le="0.1"} 1
+libhttp_client_request_duration_seconds_bucket{host="api.example.com",method="GET",status_class="2xx",le="1"} 2
+libhttp_client_request_duration_seconds_bucket{host="api.example.com",method="GET",status_class="2xx",le="+Inf"} 2
+libhttp_client_request_duration_seconds_sum{host="api.example.com",method="GET",status_class="2xx"} 0.55
+libhttp_client_request_duration_seconds_count{host="api.example.com",method="GET",status_class="2xx"} 2
+# HELP libhttp_client_requests_in_flight Requests waiting for a response or reading its body.
+# TYPE libhttp_client_requests_in_flight gauge
+libhttp_client_requests_in_flight{host="api.example.com",method="GET"} 0
+libhttp_client_requests_in_flight{host="api.example.com",method="POST"} 1
+# HELP libhttp_client_retries_total Retries made, labelled by the attempt that was retried.
+# TYPE libhttp_client_retries_total counter
+libhttp_client_retries_total{host="quoted\"host",method="GET",status_class="error"} 1
+# HELP libhttp_client_circuit_state State of the circuit for each host: 0 closed, 1 open, 2 half-open.
+# TYPE libhttp_client_circuit_state gauge
+libhttp_client_circuit_state{host="api.example.com"} 1
+# HELP libhttp_client_circuit_rejections_total Attempts failed fast while the circuit was open.
+# TYPE libhttp_client_circuit_rejections_total counter
+libhttp_client_circuit_rejections_total{host="api.example.com"} 1
+# HELP libhttp_client_rate_limit_waits_total Attempts that waited for the rate limit.
+# TYPE libhttp_client_rate_limit_waits_total counter
+libhttp_client_rate_limit_waits_total{host="api.example.com"} 1
+# HELP libhttp_client_rate_limit_wait_seconds_total Time spent waiting for the rate limit.
+# TYPE libhttp_client_rate_limit_wait_seconds_total counter
+libhttp_client_rate_limit_wait_seconds_total{host="api.example.com"} 0.25
+# HELP libhttp_client_rate_limit_rejections_total Attempts failed fast because of the rate limit.
+# TYPE libhttp_client_rate_limit_rejections_total counter
+libhttp_client_rate_limit_rejections_total{host="api.example.com"} 1
+`))
+		})
+	})
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a RoundTripper, typically to inspect or change requests
+// before calling the wrapped RoundTripper and responses after it returns.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to the http.RoundTripper interface.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps rt in the middlewares, the first of which is the outermost and
+// sees each request first.
+func chain(rt http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+type callKey struct{}
+
+// call is the state shared by the built in middlewares while making a request.
+type call struct {
+	opts     *requestOptions
+	body     *requestBody
+	attempts int
+	cached   bool
+	// stream leaves the body of the final response unread, for callers that
+	// read it themselves.
+	stream bool
+}
+
+func withCall(ctx context.Context, c *call) context.Context {
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+// callFrom returns the call for a request, or the default options for
+// requests that were not made through a Callout method.
+func callFrom(ctx context.Context) *call {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		return c
+	}
+	return &call{opts: &requestOptions{policy: DefaultRetryPolicy{}}}
+}
+
+// headerMiddleware sets the default headers, the headers for the body and the
+// headers for the request, in that order.
+func (c *Callout) headerMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		call := callFrom(req.Context())
+		req = req.Clone(req.Context())
+
+		for key, value := range c.defaultHeaders {
+			req.Header.Set(key, value)
+		}
+		if call.body != nil {
+			for key, value := range call.body.headers {
+				req.Header.Set(key, value)
+			}
+		}
+		for key, value := range call.opts.headers {
+			req.Header.Set(key, value)
+		}
+
+		return next.RoundTrip(req)
+	})
+}
+
+// retryMiddleware makes the attempts for a request until one succeeds, the
+// retry policy or a hook gives up or the retries run out. Each attempt is sent
+// with a fresh reader over the request body.
+func (c *Callout) retryMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		call := callFrom(req.Context())
+		opts := call.opts
+		ctx := req.Context()
+
+		var delay time.Duration
+		for i := 0; ; i++ {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("request cancelled: %w", err)
+			}
+
+			attempt, err := call.newAttempt(req)
+			if err != nil {
+				return nil, err
+			}
+			if err = onRequest(opts.hooks, attempt); err != nil {
+				closeRequestBody(attempt)
+				return nil, err
+			}
+
+			call.attempts++
+			resp, err := next.RoundTrip(attempt)
+			if err == nil && opts.bodyWriter == nil && !call.stream {
+				resp, err = bufferBody(resp)
+			}
+			if err == nil {
+				onResponse(opts.hooks, resp)
+			}
+
+			if !call.shouldRetry(i, attempt, resp, err) {
+				return resp, err
+			}
+			var ok bool
+			delay, ok = c.retryDelay(opts.backoff, i+1, delay, resp)
+			if !ok {
+				return resp, err
+			}
+			if !onRetry(opts.hooks, newRetry(attempt, i+1, resp, err, delay)) {
+				return resp, err
+			}
+			c.recordRetry(attempt, resp, err)
+
+			if resp != nil {
+				discardBody(resp)
+			}
+			if err = c.wait(ctx, delay); err != nil {
+				return nil, fmt.Errorf("request cancelled: %w", err)
+			}
+		}
+	})
+}
+
+// shouldRetry reports whether the request should be retried after the given
+// attempt, which got either resp or err.
+func (c *call) shouldRetry(attempt int, req *http.Request, resp *http.Response, err error) bool {
+	if attempt >= c.opts.retries || !c.body.canReplay() {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrRateLimited) &&
+			req.Context().Err() == nil && c.opts.policy.ShouldRetry(attempt+1, req, 0, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false
+	}
+	return c.opts.policy.ShouldRetry(attempt+1, req, resp.StatusCode, nil)
+}
+
+// newAttempt returns a copy of req for a single attempt, opening a fresh
+// reader over the body.
+func (c *call) newAttempt(req *http.Request) (*http.Request, error) {
+	attempt := req.Clone(req.Context())
+	if c.body == nil {
+		return attempt, nil
+	}
+
+	reader, err := c.body.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open request body: %w", err)
+	}
+	attempt.Body = reader
+	if c.body.length >= 0 {
+		attempt.ContentLength = c.body.length
+	}
+	if c.body.replayable {
+		attempt.GetBody = c.body.open
+	}
+	return attempt, nil
+}
+
+// retryDelay returns how long to wait before the given retry. A Retry-After
+// header on the previous response is honoured when it asks for longer than the
+// backoff would, and the retry is given up when it asks for longer than the
+// limit set with WithMaxRetryAfter.
+func (c *Callout) retryDelay(backoff Backoff, attempt int, previous time.Duration, resp *http.Response) (time.Duration, bool) {
+	var delay time.Duration
+	if backoff != nil {
+		delay = backoff.Delay(attempt, previous)
+	}
+	if requested, ok := retryAfter(resp, c.clock.Now()); ok && requested > delay {
+		if requested > c.maxRetryAfter {
+			return 0, false
+		}
+		delay = requested
+	}
+	return delay, true
+}
+
+func (c *Callout) wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.clock.After(delay):
+		return nil
+	}
+}
+
+// bufferBody reads the body of resp into memory, so that a failure to read it
+// fails the attempt rather than the request.
+func bufferBody(resp *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// discardBody drains and closes the body of a response that will not be
+// returned, so that its connection can be reused.
+func discardBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// closeRequestBody closes the body of a request that will not be sent.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+// onCloseBody counts the bytes read from a response body, calling onClose with
+// the count once the body is closed.
+type onCloseBody struct {
+	io.ReadCloser
+	read    int64
+	once    sync.Once
+	onClose func(read int64)
+}
+
+func (b *onCloseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+func (b *onCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.onClose(b.read) })
+	return err
+}
@@ -0,0 +1,179 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnitMiddleware(t *testing.T) {
+	spec.Run(t, "Middleware Test", testMiddleware, spec.Report(report.Terminal{}))
+}
+
+func testMiddleware(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server       *httptest.Server
+		requestCount int
+		headers      []http.Header
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requestCount = 0
+		headers = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCount++
+			headers = append(headers, r.Header.Clone())
+			if r.URL.Path == "/500" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = io.Copy(w, r.Body)
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	recordingMiddleware := func(name string, calls *[]string) client.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				*calls = append(*calls, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	when("WithMiddleware", func() {
+		it("runs the middlewares in the order they were added", func() {
+			var calls []string
+			callout := client.New(
+				client.WithMiddleware(recordingMiddleware("first", &calls), recordingMiddleware("second", &calls)),
+				client.WithMiddleware(recordingMiddleware("third", &calls)),
+			)
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal([]string{"first", "second", "third"}))
+		})
+
+		it("lets middlewares change the request", func() {
+			callout := client.New(
+				client.WithDefaultHeader("X-Default", "default"),
+				client.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+					return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						req = req.Clone(req.Context())
+						req.Header.Set("Authorization", "Signed "+req.Header.Get("X-Default"))
+						return next.RoundTrip(req)
+					})
+				}),
+			)
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headers[0].Get("Authorization")).To(Equal("Signed default"))
+		})
+
+		it("runs the middlewares for every attempt with the body", func() {
+			var bodies []string
+			callout := client.New(
+				client.WithDefaultRetries(2),
+				client.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+					return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).NotTo(HaveOccurred())
+						bodies = append(bodies, string(body))
+						req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+						return next.RoundTrip(req)
+					})
+				}),
+			)
+
+			_, err := callout.Put(server.URL+"/500", "body")
+			Expect(err).To(HaveOccurred())
+			Expect(bodies).To(Equal([]string{"body", "body", "body"}))
+			Expect(requestCount).To(Equal(3))
+		})
+
+		it("retries errors returned by a middleware", func() {
+			failures := 1
+			callout := client.New(
+				client.WithDefaultRetries(1),
+				client.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+					return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						if failures > 0 {
+							failures--
+							return nil, io.ErrUnexpectedEOF
+						}
+						return next.RoundTrip(req)
+					})
+				}),
+			)
+
+			resp, err := callout.Send(http.MethodGet, server.URL, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Attempts).To(Equal(2))
+		})
+
+		it("sees the traceparent header", func() {
+			var traceparent string
+			callout := client.New(client.WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+				return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					traceparent = req.Header.Get("traceparent")
+					return next.RoundTrip(req)
+				})
+			}))
+
+			_, err := callout.Get(server.URL, client.WithTracer(&fakeTracer{}, context.Background()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(traceparent).NotTo(BeEmpty())
+		})
+	})
+
+	when("WithTransport", func() {
+		it("sends requests through the transport", func() {
+			transport := client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusCreated,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader("stubbed")),
+					Request:    req,
+				}, nil
+			})
+			callout := client.New(client.WithTransport(transport))
+
+			resp, err := callout.Send(http.MethodGet, server.URL, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(string(resp.Body)).To(Equal("stubbed"))
+			Expect(requestCount).To(Equal(0))
+		})
+
+		it("wraps the transport in the middlewares", func() {
+			var calls []string
+			transport := client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, "transport")
+				return nil, errors.New("unreachable")
+			})
+			callout := client.New(
+				client.WithMiddleware(recordingMiddleware("middleware", &calls)),
+				client.WithTransport(transport),
+			)
+
+			_, err := callout.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("unreachable")))
+			Expect(calls).To(Equal([]string{"middleware", "transport"}))
+		})
+	})
+}
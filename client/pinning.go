@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// PinSettings configures the public key pinning added with WithPinning.
+type PinSettings struct {
+	// Pins are the base64 encoded SHA-256 hashes of the public keys that are
+	// trusted, as returned by SPKIHash, optionally prefixed with "sha256/".
+	// A connection is allowed when the leaf or an intermediate certificate
+	// has one of them, so several pins can be given while keys are rotated.
+	Pins []string
+	// ReportOnly logs connections that do not match a pin instead of
+	// failing them.
+	ReportOnly bool
+	// OnMismatch is called with every connection that does not match a pin.
+	OnMismatch func(err *PinError)
+}
+
+// PinError is returned when the certificates presented by a server do not
+// match any of the pins.
+type PinError struct {
+	Host string
+	// Hashes are the public key hashes of the certificates the server
+	// presented, starting with the leaf.
+	Hashes []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("no pinned public key found for %s, got %s", e.Host, strings.Join(e.Hashes, ", "))
+}
+
+// SPKIHash returns the base64 encoded SHA-256 hash of the public key of cert,
+// to use as a pin.
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// pinVerifier checks the certificates of each TLS connection against the
+// pins.
+type pinVerifier struct {
+	settings PinSettings
+	pins     map[string]bool
+	logger   *slog.Logger
+}
+
+func newPinVerifier(settings PinSettings, logger *slog.Logger) *pinVerifier {
+	pins := map[string]bool{}
+	for _, pin := range settings.Pins {
+		pins[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+	return &pinVerifier{settings: settings, pins: pins, logger: logger}
+}
+
+// verifyConnection allows the connection when any certificate in a verified
+// chain, or in the certificates the server presented when verification is
+// skipped, matches a pin.
+func (v *pinVerifier) verifyConnection(state tls.ConnectionState) error {
+	chains := state.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{state.PeerCertificates}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if v.pins[SPKIHash(cert)] {
+				return nil
+			}
+		}
+	}
+
+	err := &PinError{Host: state.ServerName}
+	for _, cert := range state.PeerCertificates {
+		err.Hashes = append(err.Hashes, SPKIHash(cert))
+	}
+	if v.settings.OnMismatch != nil {
+		v.settings.OnMismatch(err)
+	}
+	if v.settings.ReportOnly {
+		v.logger.Warn("public key pin mismatch", slog.String("host", err.Host), slog.Any("hashes", err.Hashes))
+		return nil
+	}
+	return err
+}
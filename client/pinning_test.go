@@ -0,0 +1,105 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnitPinning(t *testing.T) {
+	spec.Run(t, "Pinning Test", testPinning, spec.Report(report.Terminal{}))
+}
+
+func testPinning(t *testing.T, when spec.G, it spec.S) {
+	var (
+		ca       *testCA
+		leafPin  string
+		caPin    string
+		otherPin string
+		server   *httptest.Server
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		ca = newTestCA()
+		certificate, _, _ := ca.issue("server", true)
+		leafPin = client.SPKIHash(certificate.Leaf)
+		caPin = client.SPKIHash(ca.cert)
+		otherPin = client.SPKIHash(newTestCA().cert)
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+		server.StartTLS()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	newCallout := func(settings client.PinSettings, options ...client.CalloutOption) *client.Callout {
+		return client.New(append([]client.CalloutOption{client.WithCAPEM(ca.pem), client.WithPinning(settings)}, options...)...)
+	}
+
+	it("allows a server with a pinned leaf key", func() {
+		body, err := newCallout(client.PinSettings{Pins: []string{leafPin}}).Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("ok"))
+	})
+
+	it("allows a server whose chain has a pinned CA key", func() {
+		_, err := newCallout(client.PinSettings{Pins: []string{otherPin, "sha256/" + caPin}}).Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("fails with a PinError when no key is pinned", func() {
+		_, err := newCallout(client.PinSettings{Pins: []string{otherPin}}).Get(server.URL)
+		Expect(err).To(HaveOccurred())
+
+		var pinErr *client.PinError
+		Expect(errors.As(err, &pinErr)).To(BeTrue())
+		Expect(pinErr.Host).To(Equal("127.0.0.1"))
+		Expect(pinErr.Hashes).To(Equal([]string{leafPin}))
+	})
+
+	it("checks the pins when verification is skipped", func() {
+		callout := client.New(client.DefaultSkipTLSVerify(true), client.WithPinning(client.PinSettings{Pins: []string{otherPin}}))
+
+		_, err := callout.Get(server.URL)
+		var pinErr *client.PinError
+		Expect(errors.As(err, &pinErr)).To(BeTrue())
+	})
+
+	when("ReportOnly", func() {
+		it("logs the mismatch without failing the request", func() {
+			output := &bytes.Buffer{}
+			var mismatches []*client.PinError
+			callout := newCallout(client.PinSettings{
+				Pins:       []string{otherPin},
+				ReportOnly: true,
+				OnMismatch: func(err *client.PinError) {
+					mismatches = append(mismatches, err)
+				},
+			}, client.WithLogger(slog.New(slog.NewTextHandler(output, nil)), client.LogSettings{}))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mismatches).To(HaveLen(1))
+			Expect(mismatches[0].Hashes).To(Equal([]string{leafPin}))
+			Expect(output.String()).To(ContainSubstring("public key pin mismatch"))
+		})
+	})
+}
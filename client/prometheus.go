@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format, so they can be served from a metrics endpoint.
+func (m *MemoryMetrics) WritePrometheus(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := bufio.NewWriter(w)
+
+	writeHeader(out, "libhttp_client_requests_total", "counter", "Requests sent by the client, including retries.")
+	for _, labels := range sortedLabels(m.requests) {
+		writeSample(out, "libhttp_client_requests_total", requestLabels(labels), strconv.Itoa(m.requests[labels]))
+	}
+
+	writeHeader(out, "libhttp_client_request_duration_seconds", "histogram", "Time taken by requests until their response body was closed.")
+	for _, labels := range sortedLabels(m.durations) {
+		h := m.durations[labels]
+		for i, bound := range m.buckets {
+			writeSample(out, "libhttp_client_request_duration_seconds_bucket",
+				append(requestLabels(labels), "le", formatSeconds(bound)), strconv.Itoa(h.counts[i]))
+		}
+		writeSample(out, "libhttp_client_request_duration_seconds_bucket",
+			append(requestLabels(labels), "le", "+Inf"), strconv.Itoa(h.count))
+		writeSample(out, "libhttp_client_request_duration_seconds_sum", requestLabels(labels), formatSeconds(h.sum))
+		writeSample(out, "libhttp_client_request_duration_seconds_count", requestLabels(labels), strconv.Itoa(h.count))
+	}
+
+	writeHeader(out, "libhttp_client_requests_in_flight", "gauge", "Requests waiting for a response or reading its body.")
+	for _, labels := range sortedLabels(m.inFlight) {
+		writeSample(out, "libhttp_client_requests_in_flight", requestLabels(labels), strconv.Itoa(m.inFlight[labels]))
+	}
+
+	writeHeader(out, "libhttp_client_retries_total", "counter", "Retries made, labelled by the attempt that was retried.")
+	for _, labels := range sortedLabels(m.retries) {
+		writeSample(out, "libhttp_client_retries_total", requestLabels(labels), strconv.Itoa(m.retries[labels]))
+	}
+
+	writeHeader(out, "libhttp_client_circuit_state", "gauge", "State of the circuit for each host: 0 closed, 1 open, 2 half-open.")
+	for _, host := range sortedKeys(m.circuitStates) {
+		writeSample(out, "libhttp_client_circuit_state", []string{"host", host}, strconv.Itoa(int(m.circuitStates[host])))
+	}
+
+	writeHeader(out, "libhttp_client_circuit_rejections_total", "counter", "Attempts failed fast while the circuit was open.")
+	for _, host := range sortedKeys(m.circuitRejects) {
+		writeSample(out, "libhttp_client_circuit_rejections_total", []string{"host", host}, strconv.Itoa(m.circuitRejects[host]))
+	}
+
+	writeHeader(out, "libhttp_client_rate_limit_waits_total", "counter", "Attempts that waited for the rate limit.")
+	for _, host := range sortedKeys(m.limitWaits) {
+		writeSample(out, "libhttp_client_rate_limit_waits_total", []string{"host", host}, strconv.Itoa(m.limitWaits[host]))
+	}
+
+	writeHeader(out, "libhttp_client_rate_limit_wait_seconds_total", "counter", "Time spent waiting for the rate limit.")
+	for _, host := range sortedKeys(m.limitWaited) {
+		writeSample(out, "libhttp_client_rate_limit_wait_seconds_total", []string{"host", host}, formatSeconds(m.limitWaited[host]))
+	}
+
+	writeHeader(out, "libhttp_client_rate_limit_rejections_total", "counter", "Attempts failed fast because of the rate limit.")
+	for _, host := range sortedKeys(m.limitRejects) {
+		writeSample(out, "libhttp_client_rate_limit_rejections_total", []string{"host", host}, strconv.Itoa(m.limitRejects[host]))
+	}
+
+	return out.Flush()
+}
+
+func writeHeader(w *bufio.Writer, name, kind, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes a sample with labels given as name and value pairs.
+func writeSample(w *bufio.Writer, name string, labels []string, value string) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 {
+		_ = w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, `%s="%s"`, labels[i], escapeLabelValue(labels[i+1]))
+		}
+		_ = w.WriteByte('}')
+	}
+	_, _ = fmt.Fprintf(w, " %s\n", value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func requestLabels(labels MetricLabels) []string {
+	pairs := []string{"host", labels.Host, "method", labels.Method}
+	if labels.StatusClass != "" {
+		pairs = append(pairs, "status_class", labels.StatusClass)
+	}
+	return pairs
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+func sortedLabels[V any](m map[MetricLabels]V) []MetricLabels {
+	labels := make([]MetricLabels, 0, len(m))
+	for l := range m {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.StatusClass < b.StatusClass
+	})
+	return labels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
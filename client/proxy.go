@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxySettings holds the proxy options given to a Callout.
+type proxySettings struct {
+	fromEnvironment bool
+	proxyURL        string
+	noProxy         []string
+}
+
+// proxyRouter decides which proxy, if any, each connection goes through. HTTP
+// and HTTPS proxies are used by the http.Transport, while connections through
+// a SOCKS5 proxy are made by its dialer.
+type proxyRouter struct {
+	httpProxy  *url.URL
+	httpsProxy *url.URL
+	socks      *socksDialer
+	noProxy    noProxyRules
+}
+
+// router returns the proxy router for the settings, or nil when no proxy is
+// configured.
+func (s *proxySettings) router(dialer *net.Dialer) (*proxyRouter, error) {
+	r := &proxyRouter{}
+	noProxy := s.noProxy
+	if s.fromEnvironment {
+		var err error
+		if r.httpProxy, err = parseProxyURL(getenv("HTTP_PROXY")); err != nil {
+			return nil, err
+		}
+		if r.httpsProxy, err = parseProxyURL(getenv("HTTPS_PROXY")); err != nil {
+			return nil, err
+		}
+		noProxy = append(strings.Split(getenv("NO_PROXY"), ","), noProxy...)
+	}
+
+	if s.proxyURL != "" {
+		proxyURL, err := parseProxyURL(s.proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		switch proxyURL.Scheme {
+		case "socks5", "socks5h":
+			r.httpProxy, r.httpsProxy = nil, nil
+			r.socks = newSOCKSDialer(proxyURL, dialer)
+		default:
+			r.httpProxy, r.httpsProxy = proxyURL, proxyURL
+		}
+	}
+
+	if r.httpProxy == nil && r.httpsProxy == nil && r.socks == nil {
+		return nil, nil
+	}
+	r.noProxy = parseNoProxy(noProxy)
+	return r, nil
+}
+
+// proxy returns the HTTP or HTTPS proxy for req, for use as the Proxy of an
+// http.Transport.
+func (r *proxyRouter) proxy(req *http.Request) (*url.URL, error) {
+	if r.noProxy.bypass(req.URL.Host, req.URL.Scheme) {
+		return nil, nil
+	}
+	if req.URL.Scheme == "https" {
+		return r.httpsProxy, nil
+	}
+	return r.httpProxy, nil
+}
+
+// dialContext dials through the SOCKS5 proxy unless the address bypasses it.
+func (r *proxyRouter) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if r.socks == nil {
+		return dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if r.noProxy.bypass(addr, "") {
+			return dial(ctx, network, addr)
+		}
+		return r.socks.DialContext(ctx, network, addr)
+	}
+}
+
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+func getenv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// noProxyRules are hosts that bypass the proxy, in the syntax of NO_PROXY.
+type noProxyRules struct {
+	all      bool
+	networks []*net.IPNet
+	ips      []noProxyIP
+	domains  []noProxyDomain
+}
+
+type noProxyIP struct {
+	ip   net.IP
+	port string
+}
+
+type noProxyDomain struct {
+	domain    string
+	port      string
+	matchSelf bool
+}
+
+// parseNoProxy parses rules such as "*", "10.0.0.0/8", "192.168.0.1",
+// "example.com", which also matches its subdomains, ".example.com", which
+// only matches its subdomains, and "example.com:8080".
+func parseNoProxy(rules []string) noProxyRules {
+	var n noProxyRules
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		switch {
+		case rule == "":
+			continue
+		case rule == "*":
+			n.all = true
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(rule); err == nil {
+			n.networks = append(n.networks, network)
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(rule)
+		if err != nil {
+			host, port = rule, ""
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+			n.ips = append(n.ips, noProxyIP{ip: ip, port: port})
+			continue
+		}
+
+		matchSelf := !strings.HasPrefix(host, ".")
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "*"), ".")
+		n.domains = append(n.domains, noProxyDomain{domain: host, port: port, matchSelf: matchSelf})
+	}
+	return n
+}
+
+// bypass reports whether hostport, which defaults to the port for scheme,
+// matches a rule.
+func (n noProxyRules) bypass(hostport, scheme string) bool {
+	if n.all {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+		switch scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range n.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		for _, rule := range n.ips {
+			if rule.ip.Equal(ip) && (rule.port == "" || rule.port == port) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, rule := range n.domains {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, "."+rule.domain) || (rule.matchSelf && host == rule.domain) {
+			return true
+		}
+	}
+	return false
+}
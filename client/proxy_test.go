@@ -0,0 +1,335 @@
+package client_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnitProxy(t *testing.T) {
+	spec.Run(t, "Proxy Test", testProxy, spec.Report(report.Terminal{}))
+}
+
+// tunnel copies between two connections until either is closed.
+func tunnel(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		_ = a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	_ = b.Close()
+}
+
+// socksServer is a minimal SOCKS5 server that records the addresses it is
+// asked to connect to.
+type socksServer struct {
+	listener net.Listener
+	username string
+	password string
+
+	mutex     sync.Mutex
+	addresses []string
+}
+
+func newSOCKSServer(username, password string) *socksServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	s := &socksServer{listener: listener, username: username, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *socksServer) Addresses() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.addresses...)
+}
+
+func (s *socksServer) Close() {
+	_ = s.listener.Close()
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	if s.username == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+		auth := make([]byte, 2)
+		if _, err := io.ReadFull(conn, auth); err != nil {
+			return
+		}
+		username := make([]byte, auth[1])
+		_, _ = io.ReadFull(conn, username)
+		length := make([]byte, 1)
+		_, _ = io.ReadFull(conn, length)
+		password := make([]byte, length[0])
+		_, _ = io.ReadFull(conn, password)
+		if string(username) != s.username || string(password) != s.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		_, _ = io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		_, _ = io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		_, _ = io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	_, _ = io.ReadFull(conn, port)
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	s.mutex.Lock()
+	s.addresses = append(s.addresses, address)
+	s.mutex.Unlock()
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	tunnel(conn, target)
+}
+
+func testProxy(t *testing.T, when spec.G, it spec.S) {
+	var (
+		target      *httptest.Server
+		tlsTarget   *httptest.Server
+		proxy       *httptest.Server
+		proxyMutex  sync.Mutex
+		proxied     []string
+		proxyAuths  []string
+		targetCount int
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		proxied = nil
+		proxyAuths = nil
+		targetCount = 0
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			targetCount++
+			_, _ = fmt.Fprint(w, "direct")
+		})
+		target = httptest.NewServer(handler)
+		tlsTarget = httptest.NewTLSServer(handler)
+
+		proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxyMutex.Lock()
+			proxied = append(proxied, r.Method+" "+r.Host)
+			proxyAuths = append(proxyAuths, r.Header.Get("Proxy-Authorization"))
+			proxyMutex.Unlock()
+
+			if r.Method != http.MethodConnect {
+				_, _ = fmt.Fprintf(w, "proxied %s", r.URL)
+				return
+			}
+
+			upstream, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			tunnel(conn, upstream)
+		}))
+	})
+
+	it.After(func() {
+		target.Close()
+		tlsTarget.Close()
+		proxy.Close()
+	})
+
+	when("WithProxy", func() {
+		it("sends http requests to the proxy with basic authentication", func() {
+			proxyURL := strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+			callout := client.New(client.WithProxy(proxyURL))
+
+			body, err := callout.Get("http://api.example.test/widgets")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("proxied http://api.example.test/widgets"))
+			Expect(proxied).To(Equal([]string{"GET api.example.test"}))
+			Expect(proxyAuths).To(Equal([]string{"Basic dXNlcjpzZWNyZXQ="}))
+		})
+
+		it("tunnels https requests with CONNECT", func() {
+			callout := client.New(client.WithProxy(proxy.URL), client.DefaultSkipTLSVerify(true))
+
+			body, err := callout.Get(tlsTarget.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("direct"))
+			Expect(proxied).To(Equal([]string{"CONNECT " + strings.TrimPrefix(tlsTarget.URL, "https://")}))
+		})
+
+		it("fails every request when the proxy URL is not supported", func() {
+			callout := client.New(client.WithProxy("ftp://proxy.example.test"))
+
+			_, err := callout.Get(target.URL)
+			Expect(err).To(MatchError(ContainSubstring(`failed to configure proxy: unsupported proxy scheme "ftp"`)))
+		})
+	})
+
+	when("WithNoProxy", func() {
+		it("sends requests to matching hosts directly", func() {
+			host := strings.TrimPrefix(target.URL, "http://")
+			callout := client.New(client.WithProxy(proxy.URL), client.WithNoProxy(".internal.test", host))
+
+			body, err := callout.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("direct"))
+
+			_, err = callout.Get("http://db.internal.test")
+			Expect(err).To(HaveOccurred())
+
+			body, err = callout.Get("http://internal.test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("proxied http://internal.test/"))
+			Expect(proxied).To(Equal([]string{"GET internal.test"}))
+		})
+
+		it("matches CIDR ranges, domains with their subdomains and ports", func() {
+			callout := client.New(client.WithProxy(proxy.URL), client.WithNoProxy("127.0.0.0/8", "example.test:8080"))
+
+			_, err := callout.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, _ = callout.Get("http://www.example.test:8080")
+
+			body, err := callout.Get("http://www.example.test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("proxied http://www.example.test/"))
+			Expect(proxied).To(Equal([]string{"GET www.example.test"}))
+			Expect(targetCount).To(Equal(1))
+		})
+	})
+
+	when("WithProxyFromEnvironment", func() {
+		it("uses the proxies from the environment", func() {
+			t.Setenv("HTTP_PROXY", proxy.URL)
+			t.Setenv("NO_PROXY", "bypass.test")
+			callout := client.New(client.WithProxyFromEnvironment())
+
+			body, err := callout.Get("http://api.example.test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("proxied http://api.example.test/"))
+
+			_, err = callout.Get("http://bypass.test")
+			Expect(err).To(HaveOccurred())
+			Expect(proxied).To(Equal([]string{"GET api.example.test"}))
+		})
+
+		it("uses the lower case variables and HTTPS_PROXY for https requests", func() {
+			t.Setenv("https_proxy", strings.TrimPrefix(proxy.URL, "http://"))
+			callout := client.New(client.WithProxyFromEnvironment(), client.DefaultSkipTLSVerify(true))
+
+			_, err := callout.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = callout.Get(tlsTarget.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proxied).To(Equal([]string{"CONNECT " + strings.TrimPrefix(tlsTarget.URL, "https://")}))
+		})
+	})
+
+	when("SOCKS5", func() {
+		var socks *socksServer
+
+		it.After(func() {
+			socks.Close()
+		})
+
+		it("connects through the proxy with authentication", func() {
+			socks = newSOCKSServer("user", "secret")
+			callout := client.New(client.WithProxy("socks5://user:secret@" + socks.Addr()))
+
+			body, err := callout.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("direct"))
+			Expect(socks.Addresses()).To(Equal([]string{strings.TrimPrefix(target.URL, "http://")}))
+		})
+
+		it("fails when the proxy rejects the credentials", func() {
+			socks = newSOCKSServer("user", "secret")
+			callout := client.New(client.WithProxy("socks5://user:wrong@" + socks.Addr()))
+
+			_, err := callout.Get(target.URL)
+			Expect(err).To(MatchError(ContainSubstring("SOCKS authentication failed")))
+		})
+
+		it("has the proxy resolve host names with socks5h", func() {
+			socks = newSOCKSServer("", "")
+			_, port, _ := net.SplitHostPort(strings.TrimPrefix(target.URL, "http://"))
+
+			_, err := client.New(client.WithProxy("socks5h://" + socks.Addr())).Get("http://localhost:" + port)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.New(client.WithProxy("socks5://" + socks.Addr())).Get("http://localhost:" + port)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(socks.Addresses()).To(Equal([]string{"localhost:" + port, "127.0.0.1:" + port}))
+		})
+
+		it("tunnels https requests and bypasses the proxy for matching hosts", func() {
+			socks = newSOCKSServer("", "")
+			callout := client.New(client.WithProxy("socks5://"+socks.Addr()), client.DefaultSkipTLSVerify(true),
+				client.WithNoProxy(strings.TrimPrefix(target.URL, "http://")))
+
+			_, err := callout.Get(tlsTarget.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = callout.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(socks.Addresses()).To(Equal([]string{strings.TrimPrefix(tlsTarget.URL, "https://")}))
+		})
+	})
+}
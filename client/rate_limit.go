@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket that lets through Rate requests a second
+// on average, with bursts of up to Burst requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	// FailFast returns ErrRateLimited instead of waiting for the bucket to
+	// refill.
+	FailFast bool
+}
+
+type tokenBucket struct {
+	limit RateLimit
+
+	mutex       sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+	}
+}
+
+// take reserves a token and returns how long to wait before using it. A bucket
+// that fails fast only takes a token when one is available straight away, and
+// a bucket without a positive rate never refills.
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+
+	var wait time.Duration
+	if b.pausedUntil.After(now) {
+		wait = b.pausedUntil.Sub(now)
+	}
+	if b.tokens < 1 {
+		if b.limit.Rate <= 0 {
+			return 0, false
+		}
+		wait = max(wait, time.Duration((1-b.tokens)/b.limit.Rate*float64(time.Second)))
+	}
+	if wait > 0 && b.limit.FailFast {
+		return wait, false
+	}
+
+	b.tokens--
+	return wait, true
+}
+
+// giveBack returns a token taken for a request that was never made.
+func (b *tokenBucket) giveBack() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = min(b.tokens+1, float64(b.limit.Burst))
+}
+
+// pause stops handing out tokens until the given time.
+func (b *tokenBucket) pause(until time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// limitRemaining lowers the tokens to the number of requests the server says
+// are left.
+func (b *tokenBucket) limitRemaining(remaining int, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	b.tokens = min(b.tokens, float64(remaining))
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate, float64(b.limit.Burst))
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+type routeLimit struct {
+	pattern string
+	bucket  *tokenBucket
+}
+
+// rateLimiter holds the buckets added with WithRateLimit, WithHostRateLimit and
+// WithRouteRateLimit. A request has to get a token from every bucket that
+// applies to it.
+type rateLimiter struct {
+	global *tokenBucket
+	routes []routeLimit
+
+	mutex       sync.Mutex
+	hostLimits  map[string]RateLimit
+	hostBuckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		hostLimits:  map[string]RateLimit{},
+		hostBuckets: map[string]*tokenBucket{},
+	}
+}
+
+func (c *Callout) rateLimiter() *rateLimiter {
+	if c.limiter == nil {
+		c.limiter = newRateLimiter()
+	}
+	return c.limiter
+}
+
+func (l *rateLimiter) buckets(u *url.URL) []*tokenBucket {
+	var buckets []*tokenBucket
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+
+	l.mutex.Lock()
+	if bucket, ok := l.hostBuckets[u.Host]; ok {
+		buckets = append(buckets, bucket)
+	} else if limit, ok := l.hostLimits[u.Host]; ok {
+		buckets = append(buckets, l.addHostBucket(u.Host, limit))
+	} else if limit, ok := l.hostLimits["*"]; ok {
+		buckets = append(buckets, l.addHostBucket(u.Host, limit))
+	}
+	l.mutex.Unlock()
+
+	for _, route := range l.routes {
+		if matched, _ := path.Match(route.pattern, u.Host+u.Path); matched {
+			buckets = append(buckets, route.bucket)
+		}
+	}
+	return buckets
+}
+
+func (l *rateLimiter) addHostBucket(host string, limit RateLimit) *tokenBucket {
+	bucket := newTokenBucket(limit)
+	l.hostBuckets[host] = bucket
+	return bucket
+}
+
+// wait takes a token from every bucket for u, waiting until they can be used
+// or returning ErrRateLimited when a bucket that fails fast is empty. It
+// returns how long it waited.
+func (l *rateLimiter) wait(ctx context.Context, clock Clock, u *url.URL) (time.Duration, error) {
+	buckets := l.buckets(u)
+	now := clock.Now()
+
+	var wait time.Duration
+	for i, bucket := range buckets {
+		delay, ok := bucket.take(now)
+		if !ok {
+			for _, taken := range buckets[:i] {
+				taken.giveBack()
+			}
+			return 0, ErrRateLimited
+		}
+		wait = max(wait, delay)
+	}
+	if wait <= 0 {
+		return 0, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		for _, bucket := range buckets {
+			bucket.giveBack()
+		}
+		return 0, ctx.Err()
+	case <-clock.After(wait):
+		return wait, nil
+	}
+}
+
+// observe learns from the rate limit headers of a response, pausing the
+// buckets for u when the server asks the client to back off.
+func (l *rateLimiter) observe(u *url.URL, resp *http.Response, now time.Time) {
+	buckets := l.buckets(u)
+	if len(buckets) == 0 {
+		return
+	}
+
+	if delay, ok := retryAfter(resp, now); ok {
+		for _, bucket := range buckets {
+			bucket.pause(now.Add(delay))
+		}
+	}
+
+	remaining, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	if err != nil || remaining < 0 {
+		return
+	}
+	reset, err := strconv.Atoi(resp.Header.Get("RateLimit-Reset"))
+	for _, bucket := range buckets {
+		if remaining == 0 && err == nil {
+			bucket.pause(now.Add(time.Duration(reset) * time.Second))
+		}
+		bucket.limitRemaining(remaining, now)
+	}
+}
+
+// rateLimitMiddleware waits for the rate limits for each attempt, and learns
+// from the rate limit headers of the responses.
+func (c *Callout) rateLimitMiddleware(next http.RoundTripper) http.RoundTripper {
+	if c.limiter == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		waited, err := c.limiter.wait(req.Context(), c.clock, req.URL)
+		if err != nil {
+			closeRequestBody(req)
+			c.recordRejection(req.URL.Host, err)
+			return nil, fmt.Errorf("failed to make request to %s: %w", req.URL.Host, err)
+		}
+		if waited > 0 && c.metrics != nil {
+			c.metrics.RateLimitWaited(req.URL.Host, waited)
+		}
+
+		resp, err := next.RoundTrip(req)
+		if resp != nil {
+			c.limiter.observe(req.URL, resp, c.clock.Now())
+		}
+		return resp, err
+	})
+}
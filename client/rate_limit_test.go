@@ -0,0 +1,184 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnitRateLimit(t *testing.T) {
+	spec.Run(t, "Rate Limit Test", testRateLimit, spec.Report(report.Terminal{}))
+}
+
+func testRateLimit(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server       *httptest.Server
+		other        *httptest.Server
+		clock        *fakeClock
+		requestCount int
+		headers      map[string]string
+		status       int
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		clock = newFakeClock()
+		requestCount = 0
+		headers = nil
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCount++
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(status)
+		}))
+		other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	})
+
+	it.After(func() {
+		server.Close()
+		other.Close()
+	})
+
+	host := func(s *httptest.Server) string {
+		return strings.TrimPrefix(s.URL, "http://")
+	}
+
+	when("WithRateLimit", func() {
+		it("waits for the bucket to refill once the burst is used", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 2, Burst: 2}))
+
+			for i := 0; i < 4; i++ {
+				_, err := callout.Get(server.URL)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(requestCount).To(Equal(4))
+			Expect(clock.Waits()).To(Equal([]time.Duration{500 * time.Millisecond, 500 * time.Millisecond}))
+		})
+
+		it("refills the bucket over time", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 1, Burst: 1}))
+
+			_, _ = callout.Get(server.URL)
+			clock.Advance(time.Second)
+			_, _ = callout.Get(server.URL)
+
+			Expect(clock.Waits()).To(BeEmpty())
+		})
+
+		it("returns ErrRateLimited without making the request when failing fast", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 1, Burst: 1, FailFast: true}))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrRateLimited)).To(BeTrue())
+			Expect(requestCount).To(Equal(1))
+		})
+
+		it("stops waiting when the context is cancelled", func() {
+			clock.blocks = true
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 0.001, Burst: 1}))
+			_, _ = callout.Get(server.URL)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			_, err := callout.Get(server.URL, client.WithContext(ctx))
+
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(requestCount).To(Equal(1))
+		})
+	})
+
+	when("WithHostRateLimit", func() {
+		it("only limits requests to the host", func() {
+			callout := client.New(client.WithClock(clock), client.WithHostRateLimit(host(server), client.RateLimit{Rate: 1, Burst: 1, FailFast: true}))
+
+			_, _ = callout.Get(server.URL)
+			_, err := callout.Get(other.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrRateLimited)).To(BeTrue())
+		})
+
+		it("gives every host its own bucket for the wildcard host", func() {
+			callout := client.New(client.WithClock(clock), client.WithHostRateLimit("*", client.RateLimit{Rate: 1, Burst: 1, FailFast: true}))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = callout.Get(other.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = callout.Get(other.URL)
+			Expect(errors.Is(err, client.ErrRateLimited)).To(BeTrue())
+		})
+	})
+
+	when("WithRouteRateLimit", func() {
+		it("only limits requests matching the pattern", func() {
+			callout := client.New(client.WithClock(clock), client.WithRouteRateLimit(host(server)+"/orders/*", client.RateLimit{Rate: 1, Burst: 1, FailFast: true}))
+
+			_, _ = callout.Get(server.URL + "/orders/1")
+			_, err := callout.Get(server.URL + "/customers/1")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = callout.Get(server.URL + "/orders/2")
+			Expect(errors.Is(err, client.ErrRateLimited)).To(BeTrue())
+		})
+	})
+
+	when("the server sends rate limit headers", func() {
+		it("waits for the reset once no requests remain", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 100, Burst: 100}))
+
+			headers = map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "5"}
+			_, _ = callout.Get(server.URL)
+			headers = nil
+			_, _ = callout.Get(server.URL)
+
+			Expect(clock.Waits()).To(Equal([]time.Duration{5 * time.Second}))
+		})
+
+		it("pauses after a 429 with a Retry-After header", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 100, Burst: 100}))
+
+			status = http.StatusTooManyRequests
+			headers = map[string]string{"Retry-After": "3"}
+			_, err := callout.Get(server.URL)
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+
+			status = http.StatusOK
+			headers = nil
+			_, err = callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clock.Waits()).To(Equal([]time.Duration{3 * time.Second}))
+		})
+
+		it("fails fast while paused", func() {
+			callout := client.New(client.WithClock(clock), client.WithRateLimit(client.RateLimit{Rate: 100, Burst: 100, FailFast: true}))
+
+			headers = map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "5"}
+			_, _ = callout.Get(server.URL)
+
+			_, err := callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrRateLimited)).To(BeTrue())
+
+			clock.Advance(5 * time.Second)
+			_, err = callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+}
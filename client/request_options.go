@@ -9,13 +9,71 @@ import (
 type RequestOption func(*requestOptions)
 
 type requestOptions struct {
-	headers    map[string]string
-	retries    int
-	jsonValue  interface{}
-	bodyWriter io.Writer
-	tracer     trace.Tracer
-	context    context.Context
-	spanName   string
+	headers      map[string]string
+	body         bodySource
+	maxBuffered  int64
+	retries      int
+	backoff      Backoff
+	policy       RetryPolicy
+	jsonValue    interface{}
+	bodyWriter   io.Writer
+	tracer       trace.Tracer
+	traceContext context.Context
+	context      context.Context
+	spanName     string
+	hooks        []Hooks
+}
+
+// WithBody sends the contents of body as the request body, replacing any body
+// given as a string. Readers that implement io.ReaderAt and io.Seeker, such as
+// files, are re-read on every retry. Other readers are buffered in memory up to
+// the limit set with WithMaxBufferedBody so they can be retried, and larger
+// ones are streamed once and not retried.
+func WithBody(body io.Reader) RequestOption {
+	return func(r *requestOptions) {
+		r.body = func(maxBuffered int64) (*requestBody, error) {
+			return readerBody(body, maxBuffered)
+		}
+	}
+}
+
+// WithBodyBytes sends b as the request body, replacing any body given as a
+// string.
+func WithBodyBytes(b []byte) RequestOption {
+	return func(r *requestOptions) {
+		r.body = func(int64) (*requestBody, error) {
+			return bytesBody(b), nil
+		}
+	}
+}
+
+// WithBodyFunc sends the reader returned by open as the request body, calling
+// it again for every retry.
+func WithBodyFunc(open func() (io.ReadCloser, error)) RequestOption {
+	return func(r *requestOptions) {
+		r.body = func(int64) (*requestBody, error) {
+			return funcBody(open), nil
+		}
+	}
+}
+
+// WithMaxBufferedBody sets how much of a body given to WithBody is buffered in
+// memory so the request can be retried.
+func WithMaxBufferedBody(limit int64) RequestOption {
+	return func(r *requestOptions) {
+		r.maxBuffered = limit
+	}
+}
+
+// JSONBody sends v encoded as JSON as the request body, setting the
+// Content-Type and Accept headers to application/json unless they are set on
+// the request.
+func JSONBody(v interface{}) RequestOption {
+	return func(r *requestOptions) {
+		r.body = func(int64) (*requestBody, error) {
+			return jsonBody(v)
+		}
+	}
 }
 
 func UnmarshalJSONBody(v interface{}) RequestOption {
@@ -24,6 +82,14 @@ func UnmarshalJSONBody(v interface{}) RequestOption {
 	}
 }
 
+// WithContext binds the request to ctx. Cancelling ctx aborts the request in
+// flight along with any retries that have not been made yet.
+func WithContext(ctx context.Context) RequestOption {
+	return func(r *requestOptions) {
+		r.context = ctx
+	}
+}
+
 func WithHeader(name, value string) RequestOption {
 	return func(r *requestOptions) {
 		if r.headers == nil {
@@ -50,10 +116,27 @@ func WithRetries(retries int) RequestOption {
 	}
 }
 
+// WithBackoff sets how long to wait between retries of this request.
+func WithBackoff(backoff Backoff) RequestOption {
+	return func(r *requestOptions) {
+		r.backoff = backoff
+	}
+}
+
+// WithRetryPolicy sets which failures of this request are retried.
+func WithRetryPolicy(policy RetryPolicy) RequestOption {
+	return func(r *requestOptions) {
+		r.policy = policy
+	}
+}
+
+// WithTracer traces the request with tracer, starting its span as a child of
+// the span in ctx. Cancellation is only taken from the context given with
+// WithContext.
 func WithTracer(tracer trace.Tracer, ctx context.Context) RequestOption {
 	return func(r *requestOptions) {
 		r.tracer = tracer
-		r.context = ctx
+		r.traceContext = ctx
 	}
 }
 
@@ -68,3 +151,11 @@ func WriteBody(writer io.Writer) RequestOption {
 		r.bodyWriter = writer
 	}
 }
+
+// WithHooks adds hooks that observe the attempts made for the request, which
+// run after the hooks added to the Callout.
+func WithHooks(hooks Hooks) RequestOption {
+	return func(r *requestOptions) {
+		r.hooks = append(r.hooks, hooks)
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Response is the result of a request made with Send.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Trailer    http.Header
+	// Body is empty when the body was written with WriteBody.
+	Body []byte
+	// URL is the URL of the final request, after following any redirects.
+	URL *url.URL
+	// Attempts is the number of requests made, including retries.
+	Attempts int
+	// Elapsed is the time taken by all attempts and the waits between them.
+	Elapsed time.Duration
+	// Cached is set when the response came from the cache added with
+	// WithCache, including after revalidating it with the server.
+	Cached bool
+}
+
+func newResponse(resp *http.Response, body []byte) *Response {
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Trailer:    resp.Trailer,
+		Body:       body,
+		URL:        resp.Request.URL,
+	}
+}
+
+// responseBody adapts the result of Send to the methods that only return the
+// body, which is not returned alongside a ResponseError.
+func responseBody(resp *Response, err error) ([]byte, error) {
+	var respErr ResponseError
+	if resp == nil || errors.As(err, &respErr) {
+		return nil, err
+	}
+	return resp.Body, err
+}
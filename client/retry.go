@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+)
+
+// RetryPolicy decides whether a failed attempt is retried. attempt is the
+// number of attempts made so far, statusCode is the status of the response and
+// err is the error from making the request, only one of which is set. The
+// number of retries set with WithRetries is always enforced on top of the
+// policy.
+type RetryPolicy interface {
+	ShouldRetry(attempt int, req *http.Request, statusCode int, err error) bool
+}
+
+// RetryPolicyFunc adapts a function to a RetryPolicy.
+type RetryPolicyFunc func(attempt int, req *http.Request, statusCode int, err error) bool
+
+func (f RetryPolicyFunc) ShouldRetry(attempt int, req *http.Request, statusCode int, err error) bool {
+	return f(attempt, req, statusCode, err)
+}
+
+// DefaultRetryPolicy retries connection resets, idle connections closed by the
+// server, timeouts and 408, 429 and 5XX responses.
+//
+// Requests are only retried when they are idempotent: GET, HEAD, OPTIONS,
+// TRACE, PUT and DELETE requests, and requests with an Idempotency-Key header.
+// Set RetryNonIdempotent to retry other methods such as POST and PATCH as well.
+type DefaultRetryPolicy struct {
+	RetryNonIdempotent bool
+	// RetryStatusCodes replaces the status codes that are retried when set.
+	RetryStatusCodes []int
+}
+
+func (p DefaultRetryPolicy) ShouldRetry(_ int, req *http.Request, statusCode int, err error) bool {
+	if !p.RetryNonIdempotent && !isIdempotent(req) {
+		return false
+	}
+
+	if err != nil {
+		return isRetryableError(err)
+	}
+	if p.RetryStatusCodes != nil {
+		return slices.Contains(p.RetryStatusCodes, statusCode)
+	}
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= 500
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	return hasKey
+}
+
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,176 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnitRetry(t *testing.T) {
+	spec.Run(t, "Retry Test", testRetry, spec.Report(report.Terminal{}))
+}
+
+func testRetry(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server       *httptest.Server
+		requestCount atomic.Int32
+		failures     int
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		requestCount.Store(0)
+		failures = 2
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count := requestCount.Add(1)
+			if int(count) > failures {
+				_, _ = fmt.Fprintf(w, "200 on request %d", count)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/close":
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					_ = conn.Close()
+				}
+			case "/408":
+				w.WriteHeader(http.StatusRequestTimeout)
+			case "/429":
+				w.WriteHeader(http.StatusTooManyRequests)
+			case "/404":
+				w.WriteHeader(http.StatusNotFound)
+			case "/502":
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	when("DefaultRetryPolicy", func() {
+		it("retries when the server closes the connection", func() {
+			callout := client.New()
+
+			body, err := callout.Get(server.URL+"/close", client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 3"))
+		})
+
+		it("returns the last error when the retries are exhausted", func() {
+			callout := client.New()
+
+			_, err := callout.Get(server.URL+"/close", client.WithRetries(1))
+
+			Expect(err).To(MatchError(ContainSubstring("failed to make request")))
+			Expect(requestCount.Load()).To(Equal(int32(2)))
+		})
+
+		it("retries a 408 and a 429", func() {
+			callout := client.New()
+
+			body, err := callout.Get(server.URL+"/408", client.WithRetries(2))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 3"))
+
+			requestCount.Store(0)
+			body, err = callout.Get(server.URL+"/429", client.WithRetries(2))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 3"))
+		})
+
+		it("does not retry other 4XX responses", func() {
+			callout := client.New()
+
+			_, err := callout.Get(server.URL+"/404", client.WithRetries(2))
+
+			var respErr client.ResponseError
+			Expect(errors.As(err, &respErr)).To(BeTrue())
+			Expect(respErr.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(requestCount.Load()).To(Equal(int32(1)))
+		})
+
+		it("does not retry a POST when the connection is closed", func() {
+			callout := client.New()
+
+			_, err := callout.Post(server.URL+"/close", "body", client.WithRetries(2))
+
+			Expect(err).To(HaveOccurred())
+			Expect(requestCount.Load()).To(Equal(int32(1)))
+		})
+
+		it("retries a POST when non-idempotent retries are enabled", func() {
+			callout := client.New(client.WithDefaultRetryPolicy(client.DefaultRetryPolicy{RetryNonIdempotent: true}))
+
+			body, err := callout.Post(server.URL+"/close", "body", client.WithRetries(2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 3"))
+		})
+
+		it("only retries the given status codes when they are set", func() {
+			callout := client.New()
+			policy := client.WithRetryPolicy(client.DefaultRetryPolicy{RetryStatusCodes: []int{http.StatusNotFound}})
+
+			body, err := callout.Get(server.URL+"/404", client.WithRetries(2), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("200 on request 3"))
+
+			requestCount.Store(0)
+			_, err = callout.Get(server.URL+"/502", client.WithRetries(2), policy)
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+			Expect(requestCount.Load()).To(Equal(int32(1)))
+		})
+	})
+
+	when("a custom RetryPolicy", func() {
+		it("is given the attempt, request, status code and error", func() {
+			type call struct {
+				attempt    int
+				method     string
+				statusCode int
+				err        bool
+			}
+			var calls []call
+			policy := client.RetryPolicyFunc(func(attempt int, req *http.Request, statusCode int, err error) bool {
+				calls = append(calls, call{attempt, req.Method, statusCode, err != nil})
+				return true
+			})
+
+			failures = 3
+			callout := client.New(client.WithDefaultRetryPolicy(policy))
+			_, err := callout.Get(server.URL+"/404", client.WithRetries(5))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(calls).To(Equal([]call{
+				{1, http.MethodGet, http.StatusNotFound, false},
+				{2, http.MethodGet, http.StatusNotFound, false},
+				{3, http.MethodGet, http.StatusNotFound, false},
+			}))
+		})
+
+		it("stops retrying when the policy says so", func() {
+			policy := client.RetryPolicyFunc(func(attempt int, _ *http.Request, _ int, _ error) bool {
+				return attempt < 2
+			})
+
+			failures = 5
+			callout := client.New()
+			_, err := callout.Get(server.URL+"/502", client.WithRetries(5), client.WithRetryPolicy(policy))
+
+			Expect(err).To(BeAssignableToTypeOf(client.ResponseError{}))
+			Expect(requestCount.Load()).To(Equal(int32(2)))
+		})
+	})
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion          = 5
+	socksAuthNone         = 0x00
+	socksAuthPassword     = 0x02
+	socksAuthNoAcceptable = 0xff
+	socksCommandConnect   = 0x01
+	socksAddressIPv4      = 0x01
+	socksAddressDomain    = 0x03
+	socksAddressIPv6      = 0x04
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socksDialer connects through a SOCKS5 proxy, as described in RFC 1928, with
+// the username and password authentication of RFC 1929. With the socks5h
+// scheme host names are resolved by the proxy, and otherwise they are
+// resolved locally.
+type socksDialer struct {
+	proxyAddr     string
+	username      string
+	password      string
+	remoteResolve bool
+	dialer        *net.Dialer
+}
+
+func newSOCKSDialer(proxyURL *url.URL, dialer *net.Dialer) *socksDialer {
+	d := &socksDialer{
+		proxyAddr:     proxyURL.Host,
+		remoteResolve: proxyURL.Scheme == "socks5h",
+		dialer:        dialer,
+	}
+	if proxyURL.Port() == "" {
+		d.proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	if proxyURL.User != nil {
+		d.username = proxyURL.User.Username()
+		d.password, _ = proxyURL.User.Password()
+	}
+	return d
+}
+
+// DialContext connects to addr through the proxy.
+func (d *socksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port < 1 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port in address %s", addr)
+	}
+
+	if !d.remoteResolve && net.ParseIP(host) == nil {
+		host, err = d.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS proxy: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	err = d.connect(conn, host, port)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to connect through SOCKS proxy: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *socksDialer) resolve(ctx context.Context, host string) (string, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP.String(), nil
+		}
+	}
+	return addrs[0].IP.String(), nil
+}
+
+// connect negotiates authentication and asks the proxy to connect to host.
+func (d *socksDialer) connect(conn net.Conn, host string, port int) error {
+	method := byte(socksAuthNone)
+	if d.username != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case method:
+	case socksAuthNoAcceptable:
+		return errors.New("no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("unexpected SOCKS authentication method %d", reply[1])
+	}
+
+	if method == socksAuthPassword {
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	request := []byte{socksVersion, socksCommandConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		request = append(request, socksAddressDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socksAddressIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socksAddressIPv6)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	return readSOCKSReply(conn)
+}
+
+func (d *socksDialer) authenticate(conn net.Conn) error {
+	if len(d.username) > 255 || len(d.password) > 255 {
+		return errors.New("SOCKS username or password too long")
+	}
+
+	request := []byte{1, byte(len(d.username))}
+	request = append(request, d.username...)
+	request = append(request, byte(len(d.password)))
+	request = append(request, d.password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS authentication failed")
+	}
+	return nil
+}
+
+// readSOCKSReply reads the reply to a connect request, including the bound
+// address, which is not needed.
+func readSOCKSReply(conn net.Conn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	if header[1] != 0 {
+		if reason, ok := socksReplies[header[1]]; ok {
+			return errors.New(reason)
+		}
+		return fmt.Errorf("unknown SOCKS error %d", header[1])
+	}
+
+	var size int
+	switch header[3] {
+	case socksAddressIPv4:
+		size = net.IPv4len
+	case socksAddressIPv6:
+		size = net.IPv6len
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		size = int(length[0])
+	default:
+		return fmt.Errorf("unknown SOCKS address type %d", header[3])
+	}
+
+	_, err := io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsSettings holds the TLS options given to a Callout.
+type tlsSettings struct {
+	caFiles      []string
+	caPEMs       [][]byte
+	certFile     string
+	keyFile      string
+	certificates []tls.Certificate
+	minVersion   uint16
+	cipherSuites []uint16
+	serverName   string
+}
+
+// config builds the TLS configuration for the transport.
+func (s *tlsSettings) config(skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         s.minVersion,
+		CipherSuites:       s.cipherSuites,
+		ServerName:         s.serverName,
+		Certificates:       s.certificates,
+	}
+
+	if len(s.caFiles) > 0 || len(s.caPEMs) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range s.caFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", file)
+			}
+		}
+		for _, pem := range s.caPEMs {
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificates found in CA PEM")
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if s.certFile != "" {
+		reloader, err := newCertReloader(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return config, nil
+}
+
+// certReloader presents the client certificate in a pair of files, loading
+// it again whenever either file changes on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate returns the certificate to present during the TLS
+// handshake. The last certificate loaded is kept when the files are being
+// rotated and cannot be loaded yet.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certificate, err := r.load()
+	if err != nil && certificate == nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+func (r *certReloader) load() (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return r.certificate, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return r.certificate, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	if r.certificate != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.certificate, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	r.certificate = &certificate
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return r.certificate, nil
+}
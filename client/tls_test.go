@@ -0,0 +1,286 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnitTLS(t *testing.T) {
+	spec.Run(t, "TLS Test", testTLS, spec.Report(report.Terminal{}))
+}
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a certificate for commonName, along with the PEM encoded
+// certificate and key. Server certificates are valid for 127.0.0.1 and the
+// DNS names.
+func (ca *testCA) issue(commonName string, server bool, dnsNames ...string) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = dnsNames
+		if len(dnsNames) == 0 {
+			template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	Expect(err).NotTo(HaveOccurred())
+	return certificate, certPEM, keyPEM
+}
+
+func testTLS(t *testing.T, when spec.G, it spec.S) {
+	var (
+		ca          *testCA
+		server      *httptest.Server
+		clientNames []string
+		serverNames []string
+		dir         string
+	)
+
+	newServer := func(config *tls.Config) {
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverNames = append(serverNames, r.TLS.ServerName)
+			if len(r.TLS.PeerCertificates) > 0 {
+				clientNames = append(clientNames, r.TLS.PeerCertificates[0].Subject.CommonName)
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.TLS = config
+		server.StartTLS()
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		ca = newTestCA()
+		clientNames = nil
+		serverNames = nil
+		dir = t.TempDir()
+
+		certificate, _, _ := ca.issue("server", true)
+		newServer(&tls.Config{Certificates: []tls.Certificate{certificate}})
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	writeFile := func(name string, contents []byte) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, contents, 0600)).To(Succeed())
+		return path
+	}
+
+	when("custom CAs", func() {
+		it("does not trust a server signed by an unknown CA", func() {
+			_, err := client.New().Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+		})
+
+		it("trusts a server signed by a CA given as PEM", func() {
+			body, err := client.New(client.WithCAPEM(ca.pem)).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("ok"))
+		})
+
+		it("trusts a server signed by a CA in a file", func() {
+			other := newTestCA()
+			callout := client.New(client.WithCAFile(writeFile("other.pem", other.pem), writeFile("ca.pem", ca.pem)))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("fails every request when a CA file cannot be loaded", func() {
+			callout := client.New(client.WithCAFile(filepath.Join(dir, "missing.pem")))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("failed to configure TLS: failed to read CA file")))
+		})
+
+		it("fails every request when a CA file has no certificates", func() {
+			callout := client.New(client.WithCAFile(writeFile("empty.pem", []byte("not a certificate"))))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("no certificates found in CA file")))
+		})
+	})
+
+	when("mutual TLS", func() {
+		it.Before(func() {
+			server.Close()
+
+			pool := x509.NewCertPool()
+			pool.AddCert(ca.cert)
+			certificate, _, _ := ca.issue("server", true)
+			newServer(&tls.Config{
+				Certificates: []tls.Certificate{certificate},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			})
+		})
+
+		it("fails without a client certificate", func() {
+			_, err := client.New(client.WithCAPEM(ca.pem)).Get(server.URL)
+			Expect(err).To(HaveOccurred())
+		})
+
+		it("presents the client certificate", func() {
+			certificate, _, _ := ca.issue("client", false)
+			callout := client.New(client.WithCAPEM(ca.pem), client.WithClientCertificate(certificate))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientNames).To(Equal([]string{"client"}))
+		})
+
+		it("reloads the client certificate files when they change", func() {
+			_, certPEM, keyPEM := ca.issue("first", false)
+			certFile := writeFile("client.pem", certPEM)
+			keyFile := writeFile("client-key.pem", keyPEM)
+			callout := client.New(client.WithCAPEM(ca.pem), client.WithClientCertificateFile(certFile, keyFile))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, certPEM, keyPEM = ca.issue("second", false)
+			writeFile("client.pem", certPEM)
+			writeFile("client-key.pem", keyPEM)
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+			Expect(os.Chtimes(keyFile, later, later)).To(Succeed())
+			server.CloseClientConnections()
+
+			_, err = callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientNames).To(Equal([]string{"first", "second"}))
+		})
+
+		it("fails every request when the client certificate files cannot be loaded", func() {
+			callout := client.New(client.WithCAPEM(ca.pem),
+				client.WithClientCertificateFile(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing-key.pem")))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("failed to load client certificate")))
+		})
+	})
+
+	when("WithMinTLSVersion", func() {
+		it("refuses servers that do not support the version", func() {
+			server.Close()
+			certificate, _, _ := ca.issue("server", true)
+			newServer(&tls.Config{Certificates: []tls.Certificate{certificate}, MaxVersion: tls.VersionTLS12})
+
+			_, err := client.New(client.WithCAPEM(ca.pem)).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.New(client.WithCAPEM(ca.pem), client.WithMinTLSVersion(tls.VersionTLS13)).Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("protocol version")))
+		})
+	})
+
+	when("WithCipherSuites", func() {
+		it("only offers the cipher suites", func() {
+			server.Close()
+			certificate, _, _ := ca.issue("server", true)
+			newServer(&tls.Config{
+				Certificates: []tls.Certificate{certificate},
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+			})
+
+			_, err := client.New(client.WithCAPEM(ca.pem),
+				client.WithCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)).Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("handshake failure")))
+
+			_, err = client.New(client.WithCAPEM(ca.pem),
+				client.WithCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305)).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	when("WithServerName", func() {
+		it("sends the server name and verifies the certificate against it", func() {
+			server.Close()
+			certificate, _, _ := ca.issue("server", true, "api.internal")
+			newServer(&tls.Config{Certificates: []tls.Certificate{certificate}})
+
+			_, err := client.New(client.WithCAPEM(ca.pem)).Get(server.URL)
+			Expect(err).To(HaveOccurred())
+
+			_, err = client.New(client.WithCAPEM(ca.pem), client.WithServerName("api.internal")).Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serverNames).To(Equal([]string{"api.internal"}))
+		})
+	})
+}
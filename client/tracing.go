@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"github.com/sidelight-labs/libhttp/internal/tracecontext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// callSpanMiddleware starts the span covering every attempt of a request,
+// which ends once the response body is closed.
+func (c *Callout) callSpanMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		opts := callFrom(req.Context()).opts
+		if opts.tracer == nil {
+			return next.RoundTrip(req)
+		}
+
+		ctx, span := startCallSpan(req.Context(), opts, req)
+		resp, err := next.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			recordSpanResult(span, 0, 0, err)
+			span.End()
+			return nil, err
+		}
+
+		endSpanOnClose(span, resp)
+		return resp, nil
+	})
+}
+
+// attemptSpanMiddleware starts a client span for each attempt when there is a
+// tracer, and propagates the span in the context in the request headers.
+func (c *Callout) attemptSpanMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		call := callFrom(req.Context())
+		if call.opts.tracer == nil {
+			tracecontext.Inject(req.Context(), req.Header)
+			return next.RoundTrip(req)
+		}
+
+		ctx, span := startAttemptSpan(call.opts.tracer, req, call.attempts-1)
+		req = req.WithContext(ctx)
+		tracecontext.Inject(ctx, req.Header)
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			recordSpanResult(span, 0, 0, err)
+			span.End()
+			return nil, err
+		}
+
+		endSpanOnClose(span, resp)
+		return resp, nil
+	})
+}
+
+// endSpanOnClose records the status of resp on span, ending the span with the
+// size of the body once it is closed.
+func endSpanOnClose(span trace.Span, resp *http.Response) {
+	resp.Body = &onCloseBody{
+		ReadCloser: resp.Body,
+		onClose: func(read int64) {
+			recordSpanResult(span, resp.StatusCode, read, nil)
+			span.End()
+		},
+	}
+}
+
+// startCallSpan starts the span covering every attempt of a request, as a
+// child of the span in the context given with the tracer when there is one. It
+// is named after the span name given with WithSpanName, or the URL path.
+func startCallSpan(ctx context.Context, opts *requestOptions, req *http.Request) (context.Context, trace.Span) {
+	if opts.traceContext != nil {
+		ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(opts.traceContext))
+	}
+	spanName := opts.spanName
+	if spanName == "" {
+		spanName = req.URL.Path
+	}
+	return opts.tracer.Start(ctx, spanName, trace.WithAttributes(requestAttributes(req.Method, req.URL)...))
+}
+
+// startAttemptSpan starts the client span for a single attempt, as a child of
+// the span in the request context.
+func startAttemptSpan(tracer trace.Tracer, req *http.Request, attempt int) (context.Context, trace.Span) {
+	attributes := requestAttributes(req.Method, req.URL)
+	if attempt > 0 {
+		attributes = append(attributes, semconv.HTTPRequestResendCount(attempt))
+	}
+	if req.ContentLength > 0 {
+		attributes = append(attributes, semconv.HTTPRequestBodySize(int(req.ContentLength)))
+	}
+
+	return tracer.Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// requestAttributes returns the semantic convention attributes describing a
+// request. Credentials are removed from the URL.
+func requestAttributes(method string, target *url.URL) []attribute.KeyValue {
+	attributes := methodAttributes(method)
+	if target == nil {
+		return attributes
+	}
+
+	redacted := *target
+	redacted.User = nil
+	attributes = append(attributes,
+		semconv.URLFull(redacted.String()),
+		semconv.ServerAddress(target.Hostname()),
+	)
+	if port := serverPort(target); port > 0 {
+		attributes = append(attributes, semconv.ServerPort(port))
+	}
+	return attributes
+}
+
+func methodAttributes(method string) []attribute.KeyValue {
+	switch method {
+	case http.MethodConnect, http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodPatch, http.MethodPost, http.MethodPut, http.MethodTrace:
+		return []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(method)}
+	}
+	return []attribute.KeyValue{semconv.HTTPRequestMethodOther, semconv.HTTPRequestMethodOriginal(method)}
+}
+
+func serverPort(target *url.URL) int {
+	if port, err := strconv.Atoi(target.Port()); err == nil {
+		return port
+	}
+	switch target.Scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
+
+// recordSpanResult records the status of a response on span, marking the span
+// as failed when there is an error or the status is 4XX or 5XX.
+func recordSpanResult(span trace.Span, statusCode int, bodySize int64, err error) {
+	if statusCode > 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	}
+	if bodySize > 0 {
+		span.SetAttributes(semconv.HTTPResponseBodySize(int(bodySize)))
+	}
+
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetAttributes(semconv.ErrorType(err))
+		span.SetStatus(codes.Error, err.Error())
+	case statusCode >= 400:
+		span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(statusCode)))
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+}
@@ -0,0 +1,301 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnitTracing(t *testing.T) {
+	spec.Run(t, "Tracing Test", testTracing, spec.Report(report.Terminal{}))
+}
+
+// fakeTracer records the spans it starts.
+type fakeTracer struct {
+	embedded.Tracer
+
+	mutex  sync.Mutex
+	nextID byte
+	spans  []*fakeSpan
+}
+
+func (f *fakeTracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	config := trace.NewSpanStartConfig(options...)
+	parent := trace.SpanContextFromContext(ctx)
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		traceID = trace.TraceID{0xaa, 1}
+	}
+	f.nextID++
+
+	span := &fakeSpan{
+		name:       name,
+		kind:       config.SpanKind(),
+		parent:     parent,
+		attributes: map[attribute.Key]attribute.Value{},
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{0xbb, f.nextID},
+			TraceFlags: trace.FlagsSampled,
+			TraceState: parent.TraceState(),
+		}),
+	}
+	for _, kv := range config.Attributes() {
+		span.attributes[kv.Key] = kv.Value
+	}
+	f.spans = append(f.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (f *fakeTracer) Spans() []*fakeSpan {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]*fakeSpan(nil), f.spans...)
+}
+
+type fakeSpan struct {
+	embedded.Span
+
+	name        string
+	kind        trace.SpanKind
+	parent      trace.SpanContext
+	spanContext trace.SpanContext
+	attributes  map[attribute.Key]attribute.Value
+	status      codes.Code
+	errors      []error
+	ended       bool
+}
+
+func (f *fakeSpan) End(...trace.SpanEndOption)            { f.ended = true }
+func (f *fakeSpan) AddEvent(string, ...trace.EventOption) {}
+func (f *fakeSpan) AddLink(trace.Link)                    {}
+func (f *fakeSpan) IsRecording() bool                     { return !f.ended }
+func (f *fakeSpan) SpanContext() trace.SpanContext        { return f.spanContext }
+func (f *fakeSpan) SetName(name string)                   { f.name = name }
+func (f *fakeSpan) TracerProvider() trace.TracerProvider  { return nil }
+func (f *fakeSpan) SetStatus(code codes.Code, _ string)   { f.status = code }
+func (f *fakeSpan) RecordError(err error, _ ...trace.EventOption) {
+	f.errors = append(f.errors, err)
+}
+
+func (f *fakeSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		f.attributes[attr.Key] = attr.Value
+	}
+}
+
+func testTracing(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server  *httptest.Server
+		tracer  *fakeTracer
+		headers []http.Header
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		tracer = &fakeTracer{}
+		headers = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = append(headers, r.Header.Clone())
+			switch r.URL.Path {
+			case "/500":
+				w.WriteHeader(http.StatusInternalServerError)
+			case "/close":
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					_ = conn.Close()
+				}
+			default:
+				_, _ = fmt.Fprint(w, "ok")
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	parentContext := func() context.Context {
+		state, err := trace.ParseTraceState("vendor=value")
+		Expect(err).NotTo(HaveOccurred())
+		return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x11, 0x22},
+			SpanID:     trace.SpanID{0x33, 0x44},
+			TraceFlags: trace.FlagsSampled,
+			TraceState: state,
+		}))
+	}
+
+	it("starts a span for the request with a client span for the attempt", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/200", client.WithTracer(tracer, parentContext()))
+		Expect(err).NotTo(HaveOccurred())
+
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].name).To(Equal("/200"))
+		Expect(spans[0].parent.SpanID()).To(Equal(trace.SpanID{0x33, 0x44}))
+		Expect(spans[1].name).To(Equal(http.MethodGet))
+		Expect(spans[1].kind).To(Equal(trace.SpanKindClient))
+		Expect(spans[1].parent).To(Equal(spans[0].spanContext))
+		Expect(spans[0].ended).To(BeTrue())
+		Expect(spans[1].ended).To(BeTrue())
+	})
+
+	it("names the request span with the span name", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/200", client.WithTracer(tracer, context.Background()), client.WithSpanName("get-widget"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(tracer.Spans()[0].name).To(Equal("get-widget"))
+	})
+
+	it("propagates the attempt span in the traceparent and tracestate headers", func() {
+		callout := client.New(client.WithDefaultTracer(tracer, parentContext()))
+
+		_, err := callout.Get(server.URL + "/200")
+		Expect(err).NotTo(HaveOccurred())
+
+		span := tracer.Spans()[1].spanContext
+		Expect(headers[0].Get("traceparent")).To(Equal(fmt.Sprintf("00-%s-%s-01", span.TraceID(), span.SpanID())))
+		Expect(headers[0].Get("tracestate")).To(Equal("vendor=value"))
+	})
+
+	it("does not cancel requests with the context given with the tracer", func() {
+		ctx, cancel := context.WithCancel(parentContext())
+		cancel()
+		callout := client.New(client.WithDefaultTracer(tracer, ctx))
+
+		_, err := callout.Get(server.URL + "/200")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tracer.Spans()[0].parent.SpanID()).To(Equal(trace.SpanID{0x33, 0x44}))
+	})
+
+	it("keeps the span parent and cancellation apart whatever the order of the options", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/200", client.WithContext(ctx), client.WithTracer(tracer, parentContext()))
+		Expect(err).To(MatchError(ContainSubstring("context canceled")))
+		_, err = callout.Get(server.URL+"/200", client.WithTracer(tracer, parentContext()), client.WithContext(ctx))
+		Expect(err).To(MatchError(ContainSubstring("context canceled")))
+
+		_, err = callout.Get(server.URL+"/200", client.WithContext(context.Background()), client.WithTracer(tracer, parentContext()))
+		Expect(err).NotTo(HaveOccurred())
+		spans := tracer.Spans()
+		Expect(spans[len(spans)-2].parent.SpanID()).To(Equal(trace.SpanID{0x33, 0x44}))
+	})
+
+	it("propagates the span in the context when there is no tracer", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/200", client.WithContext(parentContext()))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(headers[0].Get("traceparent")).To(Equal("00-11220000000000000000000000000000-3344000000000000-01"))
+	})
+
+	it("does not send a traceparent without a span", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL + "/200")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(headers[0]).NotTo(HaveKey("Traceparent"))
+	})
+
+	it("sets the HTTP client attributes on the spans", func() {
+		callout := client.New()
+
+		url := strings.Replace(server.URL, "http://", "http://user:secret@", 1) + "/200?key=value"
+		_, err := callout.Post(url, "body", client.WithTracer(tracer, context.Background()))
+		Expect(err).NotTo(HaveOccurred())
+
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+		Expect(err).NotTo(HaveOccurred())
+		for _, span := range tracer.Spans() {
+			Expect(span.attributes["http.request.method"].AsString()).To(Equal(http.MethodPost))
+			Expect(span.attributes["url.full"].AsString()).To(Equal(server.URL + "/200?key=value"))
+			Expect(span.attributes["server.address"].AsString()).To(Equal(host))
+			Expect(fmt.Sprint(span.attributes["server.port"].AsInt64())).To(Equal(port))
+			Expect(span.attributes["http.response.status_code"].AsInt64()).To(Equal(int64(200)))
+			Expect(span.attributes["http.response.body.size"].AsInt64()).To(Equal(int64(2)))
+			Expect(span.status).To(Equal(codes.Unset))
+		}
+		Expect(tracer.Spans()[1].attributes["http.request.body.size"].AsInt64()).To(Equal(int64(4)))
+	})
+
+	it("describes unknown methods as _OTHER", func() {
+		callout := client.New()
+
+		_, err := callout.Do("PROPFIND", server.URL+"/200", "", client.WithTracer(tracer, context.Background()))
+		Expect(err).NotTo(HaveOccurred())
+
+		span := tracer.Spans()[1]
+		Expect(span.attributes["http.request.method"].AsString()).To(Equal("_OTHER"))
+		Expect(span.attributes["http.request.method_original"].AsString()).To(Equal("PROPFIND"))
+	})
+
+	it("starts a child span with the resend count for every retry", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/500", client.WithTracer(tracer, context.Background()), client.WithRetries(2))
+		Expect(err).To(HaveOccurred())
+
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(4))
+		Expect(spans[1].attributes).NotTo(HaveKey(attribute.Key("http.request.resend_count")))
+		Expect(spans[2].attributes["http.request.resend_count"].AsInt64()).To(Equal(int64(1)))
+		Expect(spans[3].attributes["http.request.resend_count"].AsInt64()).To(Equal(int64(2)))
+		for _, span := range spans[1:] {
+			Expect(span.parent).To(Equal(spans[0].spanContext))
+		}
+	})
+
+	it("marks the spans as failed on an error status", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/500", client.WithTracer(tracer, context.Background()))
+		Expect(err).To(HaveOccurred())
+
+		for _, span := range tracer.Spans() {
+			Expect(span.attributes["http.response.status_code"].AsInt64()).To(Equal(int64(500)))
+			Expect(span.attributes["error.type"].AsString()).To(Equal("500"))
+			Expect(span.status).To(Equal(codes.Error))
+			Expect(span.errors).To(BeEmpty())
+		}
+	})
+
+	it("records the error on the spans when the request fails", func() {
+		callout := client.New()
+
+		_, err := callout.Get(server.URL+"/close", client.WithTracer(tracer, context.Background()))
+		Expect(err).To(HaveOccurred())
+
+		for _, span := range tracer.Spans() {
+			Expect(span.status).To(Equal(codes.Error))
+			Expect(span.errors).To(HaveLen(1))
+			Expect(span.attributes).To(HaveKey(attribute.Key("error.type")))
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// transportSettings holds the connection options given to a Callout. Zero
+// values leave the defaults of http.Transport and net.Dialer in place.
+type transportSettings struct {
+	dialTimeout           time.Duration
+	dialKeepAlive         time.Duration
+	tlsHandshakeTimeout   time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	idleConnTimeout       time.Duration
+	responseHeaderTimeout time.Duration
+	expectContinueTimeout time.Duration
+	disableKeepAlives     bool
+}
+
+func (s transportSettings) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   s.dialTimeout,
+		KeepAlive: s.dialKeepAlive,
+	}
+}
+
+func (s transportSettings) transport(dialer *net.Dialer) *http.Transport {
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   s.tlsHandshakeTimeout,
+		MaxIdleConns:          s.maxIdleConns,
+		MaxIdleConnsPerHost:   s.maxIdleConnsPerHost,
+		MaxConnsPerHost:       s.maxConnsPerHost,
+		IdleConnTimeout:       s.idleConnTimeout,
+		ResponseHeaderTimeout: s.responseHeaderTimeout,
+		ExpectContinueTimeout: s.expectContinueTimeout,
+		DisableKeepAlives:     s.disableKeepAlives,
+	}
+}
+
+// CloseIdleConnections closes the connections kept open for reuse by the
+// transport, when it supports doing so. Connections in use are not closed.
+func (c *Callout) CloseIdleConnections() {
+	if closer, ok := c.roundTripper.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// Close closes the idle connections of the Callout. Requests made after Close
+// fail with ErrClosed, and the connections of requests still in flight are
+// closed once they finish.
+func (c *Callout) Close() error {
+	c.closed.Store(true)
+	c.CloseIdleConnections()
+	return nil
+}
+
+func (c *Callout) closeIdleConnectionsIfClosed() {
+	if c.closed.Load() {
+		c.CloseIdleConnections()
+	}
+}
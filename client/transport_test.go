@@ -0,0 +1,167 @@
+package client_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/client"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitTransport(t *testing.T) {
+	spec.Run(t, "Transport Test", testTransport, spec.Report(report.Terminal{}))
+}
+
+func testTransport(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server *httptest.Server
+		mutex  sync.Mutex
+		opened int
+		closed int
+		delay  time.Duration
+	)
+
+	openedConnections := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return opened
+	}
+	closedConnections := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return closed
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		opened, closed, delay = 0, 0, 0
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			_, _ = w.Write([]byte("ok"))
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch state {
+			case http.StateNew:
+				opened++
+			case http.StateClosed:
+				closed++
+			}
+		}
+		server.Start()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("reuses connections by default", func() {
+		callout := client.New()
+
+		for i := 0; i < 3; i++ {
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(openedConnections()).To(Equal(1))
+	})
+
+	when("WithKeepAlives", func() {
+		it("opens a connection for every request when disabled", func() {
+			callout := client.New(client.WithKeepAlives(false))
+
+			for i := 0; i < 3; i++ {
+				_, err := callout.Get(server.URL)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(openedConnections()).To(Equal(3))
+		})
+	})
+
+	when("WithMaxConnsPerHost", func() {
+		it("makes requests wait for a connection", func() {
+			delay = 10 * time.Millisecond
+			callout := client.New(client.WithMaxConnsPerHost(1))
+
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := callout.Get(server.URL)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+			}
+			wg.Wait()
+			Expect(openedConnections()).To(Equal(1))
+		})
+	})
+
+	when("WithResponseHeaderTimeout", func() {
+		it("fails when the headers take too long", func() {
+			delay = 200 * time.Millisecond
+			callout := client.New(client.WithResponseHeaderTimeout(20 * time.Millisecond))
+
+			_, err := callout.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("timeout awaiting response headers")))
+		})
+	})
+
+	when("CloseIdleConnections", func() {
+		it("closes the connections kept for reuse", func() {
+			callout := client.New()
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			callout.CloseIdleConnections()
+			Eventually(closedConnections).Should(Equal(1))
+
+			_, err = callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(openedConnections()).To(Equal(2))
+		})
+	})
+
+	when("Close", func() {
+		it("closes the connections and fails later requests", func() {
+			var hookErr error
+			callout := client.New(client.WithDefaultHooks(client.Hooks{
+				OnError: func(req *http.Request, err error) {
+					hookErr = err
+				},
+			}))
+			_, err := callout.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(callout.Close()).To(Succeed())
+			Eventually(closedConnections).Should(Equal(1))
+
+			_, err = callout.Get(server.URL)
+			Expect(errors.Is(err, client.ErrClosed)).To(BeTrue())
+			Expect(hookErr).To(Equal(client.ErrClosed))
+		})
+
+		it("closes the connections of requests in flight once they finish", func() {
+			delay = 50 * time.Millisecond
+			callout := client.New()
+
+			done := make(chan error)
+			go func() {
+				_, err := callout.Get(server.URL)
+				done <- err
+			}()
+			Eventually(openedConnections).Should(Equal(1))
+			Expect(callout.Close()).To(Succeed())
+
+			Expect(<-done).NotTo(HaveOccurred())
+			Eventually(closedConnections).Should(Equal(1))
+		})
+	})
+}
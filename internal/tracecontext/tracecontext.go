@@ -0,0 +1,103 @@
+// Package tracecontext reads and writes the W3C Trace Context headers so that
+// traces continue across calls between the client and server packages.
+package tracecontext
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	supportedVersion = "00"
+
+	// knownFlags are the trace flags that are propagated, any others are
+	// cleared as the spec requires.
+	knownFlags = trace.FlagsSampled | trace.FlagsRandom
+)
+
+// Inject writes the span context in ctx to the traceparent and tracestate
+// headers. Nothing is written when ctx has no valid span context.
+func Inject(ctx context.Context, header http.Header) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	header.Set(TraceparentHeader, fmt.Sprintf("%s-%s-%s-%s",
+		supportedVersion, sc.TraceID(), sc.SpanID(), sc.TraceFlags()&knownFlags))
+	if state := sc.TraceState().String(); state != "" {
+		header.Set(TracestateHeader, state)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// Extract returns a copy of ctx holding the remote span context read from the
+// traceparent and tracestate headers. ctx is returned unchanged when the
+// headers are missing or invalid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := parse(header.Get(TraceparentHeader), header.Get(TracestateHeader))
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func parse(traceparent, tracestate string) (trace.SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return trace.SpanContext{}, false
+	}
+
+	version := parts[0]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return trace.SpanContext{}, false
+	}
+	// Later versions may add fields, but the first four keep their meaning.
+	if version == supportedVersion && len(parts) != 4 {
+		return trace.SpanContext{}, false
+	}
+	if !isLowerHex(parts[1]) || !isLowerHex(parts[2]) || len(parts[3]) != 2 || !isLowerHex(parts[3]) {
+		return trace.SpanContext{}, false
+	}
+
+	traceID, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(parts[2])
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	// An invalid tracestate is dropped without losing the traceparent.
+	state, _ := trace.ParseTraceState(tracestate)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(flags) & knownFlags,
+		TraceState: state,
+		Remote:     true,
+	})
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
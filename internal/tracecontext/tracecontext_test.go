@@ -0,0 +1,99 @@
+package tracecontext_test
+
+import (
+	"context"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/sidelight-labs/libhttp/internal/tracecontext"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+func TestUnitTraceContext(t *testing.T) {
+	spec.Run(t, "Trace Context Test", testTraceContext, spec.Report(report.Terminal{}))
+}
+
+func testTraceContext(t *testing.T, when spec.G, it spec.S) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	extract := func(traceparent, tracestate string) trace.SpanContext {
+		header := http.Header{}
+		header.Set("traceparent", traceparent)
+		if tracestate != "" {
+			header.Set("tracestate", tracestate)
+		}
+		return trace.SpanContextFromContext(tracecontext.Extract(context.Background(), header))
+	}
+
+	when("Extract", func() {
+		it("reads a remote span context from the headers", func() {
+			sc := extract(traceparent, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+
+			Expect(sc.IsValid()).To(BeTrue())
+			Expect(sc.IsRemote()).To(BeTrue())
+			Expect(sc.TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(sc.SpanID().String()).To(Equal("00f067aa0ba902b7"))
+			Expect(sc.IsSampled()).To(BeTrue())
+			Expect(sc.TraceState().Get("rojo")).To(Equal("00f067aa0ba902b7"))
+		})
+
+		it("ignores invalid headers", func() {
+			for _, value := range []string{
+				"",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+				"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			} {
+				Expect(extract(value, "").IsValid()).To(BeFalse(), value)
+			}
+		})
+
+		it("accepts extra fields from later versions", func() {
+			sc := extract("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "")
+
+			Expect(sc.IsValid()).To(BeTrue())
+		})
+
+		it("keeps the traceparent when the tracestate is invalid", func() {
+			sc := extract(traceparent, "not a valid tracestate")
+
+			Expect(sc.IsValid()).To(BeTrue())
+			Expect(sc.TraceState().Len()).To(Equal(0))
+		})
+
+		it("clears unknown flags", func() {
+			sc := extract("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-ff", "")
+
+			Expect(sc.TraceFlags()).To(Equal(trace.FlagsSampled | trace.FlagsRandom))
+		})
+	})
+
+	when("Inject", func() {
+		it("writes the span context to the headers", func() {
+			sc := extract(traceparent, "rojo=00f067aa0ba902b7")
+			header := http.Header{}
+
+			tracecontext.Inject(trace.ContextWithSpanContext(context.Background(), sc), header)
+
+			Expect(header.Get("traceparent")).To(Equal(traceparent))
+			Expect(header.Get("tracestate")).To(Equal("rojo=00f067aa0ba902b7"))
+		})
+
+		it("writes nothing without a valid span context", func() {
+			header := http.Header{}
+
+			tracecontext.Inject(context.Background(), header)
+
+			Expect(header).To(BeEmpty())
+		})
+	})
+}
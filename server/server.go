@@ -3,11 +3,12 @@ package server
 import (
 	"fmt"
 	"github.com/sidelight-labs/libc/logger"
+	"github.com/sidelight-labs/libhttp/internal/tracecontext"
 	"net/http"
 )
 
 func ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, logRequest(http.DefaultServeMux))
+	return http.ListenAndServe(addr, logRequest(continueTrace(http.DefaultServeMux)))
 }
 
 func logRequest(handler http.Handler) http.Handler {
@@ -16,3 +17,13 @@ func logRequest(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// continueTrace makes the trace context sent by the caller available from the
+// request context, so spans started while handling the request join the
+// caller's trace.
+func continueTrace(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracecontext.Extract(r.Context(), r.Header)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}